@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/cache"
+	"github.com/cedrickring/diana/pkg/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	useCache     bool
+	cacheDir     string
+	cacheMaxSize string
+)
+
+func addCacheFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&useCache, "cache", "", false, "Keep pulled layers in a local cache shared across runs")
+	cmd.PersistentFlags().StringVarP(&cacheDir, "cache-dir", "", cache.DefaultDir(), "Directory of the layer cache")
+	cmd.PersistentFlags().StringVarP(&cacheMaxSize, "cache-max-size", "", util.FormatSize(cache.DefaultMaxSize), "Size cap of the layer cache, least recently used layers are evicted first")
+}
+
+// cacheEntry is a cached blob in --json output
+type cacheEntry struct {
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+func newCache() (*cache.Cache, error) {
+	maxSize, err := util.ParseSize(cacheMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return cache.New(cacheDir, maxSize), nil
+}
+
+func newCacheCommand() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the local layer cache",
+	}
+
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:   "ls",
+		Short: "List all cached blobs",
+		Args:  cobra.NoArgs,
+		Run: run(func(*cobra.Command, []string) error {
+			c, err := openCache()
+			if err != nil {
+				return err
+			}
+			entries, err := c.Entries()
+			if err != nil {
+				return fail(err, "Failed to list the cache")
+			}
+
+			result := []cacheEntry{}
+			for _, e := range entries {
+				result = append(result, cacheEntry{Digest: e.Digest, Size: e.Size, LastUsed: e.LastUsed})
+			}
+			if setResult(result) {
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "DIGEST\tSIZE\tLAST USED")
+			var total int64
+			for _, e := range entries {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", e.Digest, util.FormatSize(e.Size), e.LastUsed.Format("2006-01-02 15:04:05"))
+				total += e.Size
+			}
+			w.Flush()
+			fmt.Printf("%d blobs, %s total\n", len(entries), util.FormatSize(total))
+			return nil
+		}),
+	})
+
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:   "prune",
+		Short: "Evict least recently used blobs until the cache fits into --cache-max-size",
+		Args:  cobra.NoArgs,
+		Run: run(func(*cobra.Command, []string) error {
+			maxSize, err := util.ParseSize(cacheMaxSize)
+			if err != nil {
+				return failf("Invalid --cache-max-size: %v", err)
+			}
+
+			c, err := openCache()
+			if err != nil {
+				return err
+			}
+			removed, err := c.Prune(maxSize)
+			if err != nil {
+				return fail(err, "Failed to prune the cache")
+			}
+
+			result := []cacheEntry{}
+			var freed int64
+			for _, e := range removed {
+				logrus.Infof("Removed %s", e.Digest)
+				result = append(result, cacheEntry{Digest: e.Digest, Size: e.Size, LastUsed: e.LastUsed})
+				freed += e.Size
+			}
+			setResult(result)
+			logrus.Infof("Freed %s", util.FormatSize(freed))
+			return nil
+		}),
+	})
+
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Remove all cached blobs",
+		Args:  cobra.NoArgs,
+		Run: run(func(*cobra.Command, []string) error {
+			c, err := openCache()
+			if err != nil {
+				return err
+			}
+			if err := c.Clear(); err != nil {
+				return fail(err, "Failed to clear the cache")
+			}
+			logrus.Infof("Cleared cache at %s", cacheDir)
+			return nil
+		}),
+	})
+
+	return cacheCmd
+}
+
+// openCache returns the layer cache configured by the cache flags
+func openCache() (*cache.Cache, error) {
+	c, err := newCache()
+	if err != nil {
+		return nil, failf("Invalid --cache-max-size: %v", err)
+	}
+	return c, nil
+}
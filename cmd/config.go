@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/docker"
+	"github.com/cedrickring/diana/pkg/util"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const envPrefix = "DIANA_"
+
+var (
+	configFile string
+	profile    string
+
+	registryConfigs = map[string]registryConfig{}
+)
+
+// configFileContent is the content of config.yaml, the settings are named like the global flags
+type configFileContent struct {
+	// Profile is used if neither --profile nor DIANA_PROFILE are set
+	Profile    string                    `yaml:"profile"`
+	Registries map[string]registryConfig `yaml:"registries"`
+	Profiles   map[string]profileConfig  `yaml:"profiles"`
+	Settings   map[string]interface{}    `yaml:",inline"`
+}
+
+// profileConfig overrides the settings and registries of the config file
+type profileConfig struct {
+	Registries map[string]registryConfig `yaml:"registries"`
+	Settings   map[string]interface{}    `yaml:",inline"`
+}
+
+type registryConfig struct {
+	// Mirrors are tried in order before the registry itself
+	Mirrors  []string `yaml:"mirrors"`
+	Insecure bool     `yaml:"insecure"`
+	CAFile   string   `yaml:"ca-file"`
+	// Credentials is where the credentials come from: docker (default), env or anonymous
+	Credentials string `yaml:"credentials"`
+	UsernameEnv string `yaml:"username-env"`
+	PasswordEnv string `yaml:"password-env"`
+}
+
+// slice flags append values if set again, so they have to be reset to be overridden
+var sliceFlags = map[string]*[]string{
+	"insecure-registry": &insecureRegistries,
+}
+
+// defaultConfigFile returns $XDG_CONFIG_HOME/diana/config.yaml or ~/.config/diana/config.yaml
+func defaultConfigFile() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "diana", "config.yaml")
+	}
+	return filepath.Join(util.HomeDir(), ".config", "diana", "config.yaml")
+}
+
+// applyConfig sets the global flags which weren't specified from the config file, environment
+// variables override both
+func applyConfig(cmd *cobra.Command) error {
+	file := configFile
+	if env, ok := os.LookupEnv(envPrefix + "CONFIG"); ok {
+		file = env
+	}
+
+	content, err := readConfigFile(file, cmd.Flags().Changed("config") || file != configFile)
+	if err != nil {
+		return err
+	}
+
+	selected := content.Profile
+	if cmd.Flags().Changed("profile") {
+		selected = profile
+	}
+	if env, ok := os.LookupEnv(envPrefix + "PROFILE"); ok {
+		selected = env
+	}
+
+	settings := content.Settings
+	registries := content.Registries
+	if selected != "" {
+		p, ok := content.Profiles[selected]
+		if !ok {
+			return errors.Errorf("unknown profile %s", selected)
+		}
+		settings = merge(settings, p.Settings)
+		for host, r := range p.Registries {
+			if registries == nil {
+				registries = map[string]registryConfig{}
+			}
+			registries[host] = r
+		}
+	}
+
+	for key, value := range settings {
+		f, err := configFlag(cmd, key)
+		if err != nil {
+			return err
+		}
+		if f == nil || f.Changed {
+			continue
+		}
+		if err := f.Value.Set(settingValue(value)); err != nil {
+			return errors.Wrapf(err, "invalid value for %s", key)
+		}
+	}
+
+	if err := applyEnv(cmd); err != nil {
+		return err
+	}
+
+	return applyRegistries(registries)
+}
+
+// readConfigFile reads the config file, a missing file is only an error if it was specified
+func readConfigFile(file string, specified bool) (*configFileContent, error) {
+	content := &configFileContent{}
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !specified {
+		return content, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading config file")
+	}
+
+	if err := yaml.UnmarshalStrict(b, content); err != nil {
+		return nil, errors.Wrapf(err, "parsing config file %s", file)
+	}
+	return content, nil
+}
+
+// configFlag returns the flag set by the setting, nil if the setting doesn't apply to the command
+func configFlag(cmd *cobra.Command, key string) (*pflag.Flag, error) {
+	if key == "output-dir" {
+		//the output of other commands is a file
+		if cmd.Name() != "extract" {
+			return nil, nil
+		}
+		return cmd.Flags().Lookup("output"), nil
+	}
+
+	if key == "config" || cmd.Root().PersistentFlags().Lookup(key) == nil {
+		return nil, errors.Errorf("unknown setting %s", key)
+	}
+	return cmd.Flags().Lookup(key), nil
+}
+
+// applyEnv sets the global flags from DIANA_<FLAG> environment variables, e.g. DIANA_CACHE_DIR
+func applyEnv(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		key := f.Name
+		switch {
+		case key == "output" && cmd.Name() == "extract":
+			key = "output-dir"
+		case key == "config" || key == "profile" || cmd.Root().PersistentFlags().Lookup(key) == nil:
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(key))
+		if !ok || err != nil {
+			return
+		}
+		if slice, ok := sliceFlags[f.Name]; ok {
+			*slice = nil
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = errors.Wrapf(setErr, "invalid value for %s", envName(key))
+		}
+		f.Changed = true
+	})
+	return err
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// applyRegistries adds the options of the registries to the global flags
+func applyRegistries(registries map[string]registryConfig) error {
+	for host, r := range registries {
+		switch r.Credentials {
+		case "", "docker", "anonymous":
+		case "env":
+			if r.UsernameEnv == "" || r.PasswordEnv == "" {
+				return errors.Errorf("registry %s: env credentials need username-env and password-env", host)
+			}
+		default:
+			return errors.Errorf("registry %s: unknown credentials %s, expected docker, env or anonymous", host, r.Credentials)
+		}
+
+		if r.Insecure {
+			insecureRegistries = append(insecureRegistries, host)
+		}
+		registryConfigs[registryHost(host)] = r
+	}
+	return nil
+}
+
+// registryHost normalizes the host like image references do, e.g. docker.io is index.docker.io
+func registryHost(host string) string {
+	if registry, err := name.NewRegistry(host, name.WeakValidation); err == nil {
+		return registry.RegistryStr()
+	}
+	return host
+}
+
+// registryCredentials returns the credentials from the source configured for the registry
+func registryCredentials(host string) (string, string, error) {
+	r := registryConfigs[host]
+	switch r.Credentials {
+	case "anonymous":
+		return "", "", nil
+	case "env":
+		return os.Getenv(r.UsernameEnv), os.Getenv(r.PasswordEnv), nil
+	default:
+		return docker.GetCredentials(host)
+	}
+}
+
+func registryMirrors() map[string][]string {
+	mirrors := map[string][]string{}
+	for host, r := range registryConfigs {
+		mirrors[host] = r.Mirrors
+	}
+	return mirrors
+}
+
+func registryCAFiles() map[string]string {
+	caFiles := map[string]string{}
+	for host, r := range registryConfigs {
+		if r.CAFile != "" {
+			caFiles[host] = r.CAFile
+		}
+	}
+	return caFiles
+}
+
+func merge(settings, overrides map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for key, value := range settings {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
+// settingValue formats the setting like it would be passed as flag, lists are comma separated
+func settingValue(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	var values []string
+	for _, v := range list {
+		values = append(values, fmt.Sprint(v))
+	}
+	return strings.Join(values, ",")
+}
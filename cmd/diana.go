@@ -1,16 +1,11 @@
 package main
 
 import (
-	"io"
-	"io/ioutil"
+	"context"
 	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
-	"github.com/cedrickring/diana/pkg/docker"
-	"github.com/cedrickring/diana/pkg/registry"
-	"github.com/cedrickring/diana/pkg/tar"
-	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/cedrickring/diana/pkg/containerd"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -19,122 +14,149 @@ var (
 	image            string
 	includeBaseLayer bool
 	forceTTYColors   bool
+	verbosity        int
+	quiet            bool
+	logFormat        string
+
+	insecureRegistries []string
+	caFile             string
+	certFile           string
+	keyFile            string
+	timeout            time.Duration
+	concurrency        int
+	platform           string
+	imageSource        string
+
+	containerdRoot      string
+	containerdNamespace string
 )
 
 func main() {
-
-	rootCmd := cobra.Command{
-		Use: "diana",
-		Run: runCommand,
+	rootCmd := &cobra.Command{
+		Use:  "diana",
+		Args: cobra.ArbitraryArgs,
+		Run:  run(runCommand),
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			currentReport.Command = commandName(cmd)
+			configErr := applyConfig(cmd)
+			if err := setupLogrus(); err != nil {
+				reportError(err)
+				exit(1)
+			}
+			if configErr != nil {
+				reportError(failf("Invalid configuration: %v", configErr))
+				exit(1)
+			}
+		},
+		PersistentPostRun: func(*cobra.Command, []string) {
+			finishReport()
+		},
 	}
 
-	rootCmd.Flags().StringVarP(&image, "image", "i", "", "Full image name")
-	rootCmd.Flags().BoolVarP(&includeBaseLayer, "base-layer", "", false, "Specify to also pull the base image layer")
-	rootCmd.Flags().BoolVarP(&forceTTYColors, "color", "c", false, "Force logrus coloful output")
+	rootCmd.Flags().StringVarP(&image, "image", "i", "", "Full image name, optionally prefixed with a transport (docker://, docker-daemon:, docker-archive:, oci:, oci-archive:, containerd:)")
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "", defaultConfigFile(), "Config file with defaults for the global flags, registries and profiles")
+	rootCmd.PersistentFlags().StringVarP(&profile, "profile", "", "", "Profile of the config file to use")
+	rootCmd.PersistentFlags().BoolVarP(&includeBaseLayer, "base-layer", "", false, "Specify to also pull the base image layer")
+	rootCmd.PersistentFlags().BoolVarP(&forceTTYColors, "color", "c", false, "Force logrus coloful output")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "Log debug messages, repeat (-vv) to log HTTP requests and responses with credentials redacted")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only log errors, no progress is shown")
+	rootCmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", "text", "Format of the logs written to stderr: text or json")
+	rootCmd.PersistentFlags().StringSliceVarP(&insecureRegistries, "insecure-registry", "", nil, "Registries which may be reached via plain http or without TLS verification")
+	rootCmd.PersistentFlags().StringVarP(&caFile, "ca-file", "", "", "Additional CA certificate to verify registries with")
+	rootCmd.PersistentFlags().StringVarP(&certFile, "cert", "", "", "Client certificate for registries requiring mutual TLS")
+	rootCmd.PersistentFlags().StringVarP(&keyFile, "key", "", "", "Private key of the client certificate")
+	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "", 0, "Abort if pulling the image takes longer than this (e.g. 5m)")
+	rootCmd.PersistentFlags().IntVarP(&concurrency, "concurrency", "", 3, "Number of layers downloaded at once")
+	rootCmd.PersistentFlags().StringVarP(&platform, "platform", "", "", "Platform to pick from multi-platform images as os/arch[/variant] (default linux/<current arch>)")
+	rootCmd.PersistentFlags().StringVarP(&imageSource, "source", "", "registry", "Where to get images without transport prefix from: registry or daemon (local Docker or Podman)")
+	rootCmd.PersistentFlags().StringVarP(&containerdRoot, "containerd-root", "", containerd.DefaultRoot, "Root directory of containerd for containerd: images")
+	rootCmd.PersistentFlags().StringVarP(&containerdNamespace, "containerd-namespace", "", "", "Namespace of containerd: images, all namespaces are searched if empty")
+	rootCmd.PersistentFlags().StringVarP(&fromLayer, "from-layer", "", "", "Use the filesystem as it was after the layer with this index (starting at 0) or digest")
+	rootCmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "", false, "Only print which layers would be pulled and their size, no layers are downloaded")
+	rootCmd.PersistentFlags().BoolVarP(&noProgress, "no-progress", "", false, "Don't show progress bars or log the progress of layer downloads")
+	rootCmd.PersistentFlags().BoolVarP(&jsonOutput, "json", "", false, "Print a JSON document with the result and errors of the command to stdout")
+	rootCmd.PersistentFlags().BoolVarP(&includeDeleted, "include-deleted", "", false, "Also consider files deleted by later layers, using their last version")
 	rootCmd.MarkFlagRequired("image")
-
-	rootCmd.Execute()
-}
-
-func runCommand(_ *cobra.Command, args []string) {
-	setupLogrus()
-
-	if len(args) == 0 {
-		logrus.Fatalf("Please specify the file to be extracted as the first argument")
-	}
-	fileName := args[0]
-
-	tag, err := name.NewTag(image, name.WeakValidation)
-	if err != nil {
-		logrus.Fatal(err)
-	}
-
-	var username, password string
-	if !strings.HasPrefix(tag.RepositoryStr(), "library") {
-		username, password, err = docker.GetCredentials(tag.RegistryStr())
-		if err != nil {
-			logrus.WithError(err).Fatalf("Couldn't find registry credentials")
+	addCacheFlags(rootCmd)
+	addExtractFlags(rootCmd)
+
+	rootCmd.AddCommand(newExtractCommand())
+	rootCmd.AddCommand(newLsCommand())
+	rootCmd.AddCommand(newCatCommand())
+	rootCmd.AddCommand(newDiffCommand())
+	rootCmd.AddCommand(newExportCommand())
+	rootCmd.AddCommand(newSBOMCommand())
+	rootCmd.AddCommand(newVersionCommand())
+	rootCmd.AddCommand(newSecretsCommand())
+	rootCmd.AddCommand(newHistoryCommand())
+	rootCmd.AddCommand(newCacheCommand())
+	rootCmd.AddCommand(newServeCommand())
+	rootCmd.AddCommand(newSyncCommand())
+	rootCmd.AddCommand(newLockCommand())
+
+	//cobra prints its errors and the usage to stderr, the report is printed if --json was parsed
+	if err := rootCmd.Execute(); err != nil {
+		if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil {
+			currentReport.Command = commandName(cmd)
 		}
+		currentReport.Error = &errorReport{Code: codeInvalidArgument, Message: err.Error()}
+		exit(1)
 	}
+}
 
-	var client registry.Client
-	if tag.RegistryStr() == name.DefaultRegistry { //Docker Hub
-		client = registry.NewDockerHubRegistryClient(username, password)
-	} else {
-		client = registry.NewV2RegistryClient(username, password)
-	}
-
-	manifest, err := client.GetManifest(image)
-	if err != nil {
-		logrus.WithError(err).Fatalf("Failed to get manifest for image %s", image)
-	}
-
-	layers := manifest.Layers
-	if !includeBaseLayer {
-		//drop the first layer as it's probably the base image
-		layers = layers[1:]
-	}
-
-	tmp, err := ioutil.TempDir("", "diana")
-	if err != nil {
-		logrus.WithError(err).Errorf("Can't create temporary directory. Please check rights for this executable.")
-		return
+// commandName returns the name of the subcommand, the root command extracts files
+func commandName(cmd *cobra.Command) string {
+	if !cmd.HasParent() {
+		return "extract"
 	}
+	return cmd.Name()
+}
 
-	var tarFiles []string
-	for _, layer := range layers {
-		logrus.Infof("Pulling layer %s (%d B)", layer.Digest, layer.Size)
-
-		f, _ := ioutil.TempFile(tmp, "*.tar.gz")
-		err := client.PullLayer(image, &layer, f)
-		if err != nil {
-			logrus.Errorln(err)
-			f.Close()
-			return
-		}
-
-		tarFiles = append(tarFiles, f.Name())
-		f.Close()
+func runCommand(_ *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return failf("Please specify the file to be extracted as the first argument")
 	}
-	defer func() {
-		os.RemoveAll(tmp)
-	}()
 
-	path := filepath.Join(tmp, "image")
-	for _, f := range tarFiles {
-		tar.UnTar(path, f)
-	}
+	ctx, cancel := commandContext()
+	defer cancel()
 
-	search := filepath.Join(path, fileName)
-	f, err := os.Open(search)
-	if err != nil {
-		logrus.Errorf(`The file "%v" doesn't exist in the image`, fileName)
-		return
-	}
-	defer f.Close()
+	return extractFiles(ctx, image, args, ".")
+}
 
-	fileName = filepath.ToSlash(fileName)
-	if strings.Contains(fileName, "/") {
-		fileName = fileName[strings.LastIndex(fileName, "/")+1:]
+// commandContext returns the context for a command honoring --timeout
+func commandContext() (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
 	}
+	return context.WithCancel(context.Background())
+}
 
-	target, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, os.ModePerm)
-	if err != nil {
-		logrus.WithError(err).Errorf("Couldn't create target file")
-		return
+// setupLogrus logs to stderr, so stdout is free for the output of commands
+func setupLogrus() error {
+	logrus.SetOutput(os.Stderr)
+	logrus.StandardLogger().ExitFunc = exit
+
+	switch logFormat {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{
+			ForceColors: forceTTYColors,
+		})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return failf("Unknown log format %s, expected text or json", logFormat)
 	}
-	defer target.Close()
 
-	if _, err = io.Copy(target, f); err != nil {
-		logrus.Fatal(err)
+	switch {
+	case quiet && verbosity > 0:
+		return failf("--quiet and --verbose can't be combined")
+	case quiet:
+		logrus.SetLevel(logrus.ErrorLevel)
+		noProgress = true
+	case verbosity == 1:
+		logrus.SetLevel(logrus.DebugLevel)
+	case verbosity > 1:
+		logrus.SetLevel(logrus.TraceLevel)
 	}
-
-	logrus.Infof("Extracted file to ./%s", fileName)
-}
-
-func setupLogrus() {
-	logrus.SetFormatter(&logrus.TextFormatter{
-		ForceColors: forceTTYColors,
-	})
-	logrus.SetOutput(os.Stdout)
+	return nil
 }
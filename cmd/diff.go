@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cedrickring/diana/pkg/tar"
+	"github.com/spf13/cobra"
+)
+
+func newDiffCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <image1> <image2>",
+		Short: "Show files added (+), removed (-) or changed (~) between two images",
+		Args:  cobra.ExactArgs(2),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				return planImages(ctx, &opts, args...)
+			}
+
+			from, closeFrom, err := loadImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeFrom()
+			to, closeTo, err := loadImage(ctx, args[1], opts)
+			if err != nil {
+				return err
+			}
+			defer closeTo()
+
+			changes := diffIndexes(from.Index(), to.Index())
+			if setResult(diffResult(args[0], args[1], changes)) {
+				return nil
+			}
+
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+			return nil
+		}),
+	}
+}
+
+// Kinds of changes between two images
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// changeSymbols prefix the paths in the text output
+var changeSymbols = map[string]string{changeAdded: "+", changeRemoved: "-", changeChanged: "~"}
+
+type change struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+}
+
+func (c change) String() string {
+	return changeSymbols[c.Change] + " " + c.Path
+}
+
+// diffIndexes compares the file headers of both indexes, contents aren't compared
+func diffIndexes(from, to *tar.Index) []change {
+	changes := []change{}
+
+	for _, e := range from.Entries() {
+		other, ok := to.Lookup(e.Path)
+		switch {
+		case !ok:
+			changes = append(changes, change{Path: e.Path, Change: changeRemoved})
+		case headerChanged(e, other):
+			changes = append(changes, change{Path: e.Path, Change: changeChanged})
+		}
+	}
+	for _, e := range to.Entries() {
+		if _, ok := from.Lookup(e.Path); !ok {
+			changes = append(changes, change{Path: e.Path, Change: changeAdded})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// diffResult is the --json output of diff
+func diffResult(from, to string, changes []change) interface{} {
+	return struct {
+		From    string   `json:"from"`
+		To      string   `json:"to"`
+		Changes []change `json:"changes"`
+	}{From: from, To: to, Changes: changes}
+}
+
+func headerChanged(a, b *tar.Entry) bool {
+	ha, hb := a.Header, b.Header
+	if a.IsDir() && b.IsDir() {
+		return ha.Mode != hb.Mode || ha.Uid != hb.Uid || ha.Gid != hb.Gid
+	}
+	return ha.Typeflag != hb.Typeflag ||
+		ha.Mode != hb.Mode ||
+		ha.Size != hb.Size ||
+		ha.Uid != hb.Uid ||
+		ha.Gid != hb.Gid ||
+		ha.Linkname != hb.Linkname ||
+		!ha.ModTime.Equal(hb.ModTime)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+)
+
+func TestDiffIndexes(t *testing.T) {
+	from := dianatar.NewIndex()
+	from.Add(0, &tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755})
+	from.Add(0, &tar.Header{Name: "etc/hello", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	from.Add(0, &tar.Header{Name: "etc/removed", Typeflag: tar.TypeReg, Mode: 0644})
+	from.Add(0, &tar.Header{Name: "etc/same", Typeflag: tar.TypeReg, Mode: 0644})
+
+	to := dianatar.NewIndex()
+	to.Add(0, &tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755})
+	to.Add(0, &tar.Header{Name: "etc/hello", Typeflag: tar.TypeReg, Mode: 0644, Size: 8})
+	to.Add(0, &tar.Header{Name: "etc/added", Typeflag: tar.TypeReg, Mode: 0644})
+	to.Add(0, &tar.Header{Name: "etc/same", Typeflag: tar.TypeReg, Mode: 0644})
+
+	expected := []change{
+		{Path: "/etc/added", Change: changeAdded},
+		{Path: "/etc/hello", Change: changeChanged},
+		{Path: "/etc/removed", Change: changeRemoved},
+	}
+	changes := diffIndexes(from, to)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+	if s := changes[0].String(); s != "+ /etc/added" {
+		t.Errorf("expected + /etc/added, got %s", s)
+	}
+
+	if changes := diffIndexes(to, to); changes == nil || len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var exportOutput string
+
+func newExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <image>",
+		Short: "Write the merged filesystem of an image as a flattened rootfs tarball",
+		Args:  cobra.ExactArgs(1),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			toStdout := exportOutput == "" || exportOutput == "-"
+			if toStdout && jsonOutput {
+				return failf("--json needs -o, stdout is used for the JSON document")
+			}
+			if toStdout && isTerminal(os.Stdout) {
+				return failf("Refusing to write a tarball to a terminal, use -o or redirect stdout")
+			}
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			//a rootfs isn't of much use without its base image
+			opts.IncludeBaseLayer = true
+
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			fs, closeImage, err := loadImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			if toStdout {
+				if err := fs.Export(ctx, os.Stdout); err != nil {
+					return fail(err, "Failed to export image %s", args[0])
+				}
+				return nil
+			}
+
+			//write next to the target first, so a failed export doesn't leave a truncated tarball behind
+			f, err := ioutil.TempFile(filepath.Dir(exportOutput), ".diana-export-*")
+			if err != nil {
+				return fail(err, "Can't create %s", exportOutput)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			hash := sha256.New()
+			if err := fs.Export(ctx, io.MultiWriter(f, hash)); err != nil {
+				return fail(err, "Failed to export image %s", args[0])
+			}
+			if err := f.Close(); err != nil {
+				return fail(err, "Failed to write %s", exportOutput)
+			}
+			if err := os.Rename(f.Name(), exportOutput); err != nil {
+				return fail(err, "Failed to write %s", exportOutput)
+			}
+
+			if fi, err := os.Stat(exportOutput); err == nil {
+				currentReport.Files = append(currentReport.Files, diana.File{
+					Destination: exportOutput,
+					Type:        "file",
+					Mode:        fi.Mode().String(),
+					Size:        fi.Size(),
+					SHA256:      hex.EncodeToString(hash.Sum(nil)),
+				})
+			}
+			logrus.Infof("Exported image %s to %s", args[0], exportOutput)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write the tarball to, stdout if empty or -")
+	return cmd
+}
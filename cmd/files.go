@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/tar"
+	"github.com/spf13/cobra"
+)
+
+var (
+	outputDir     string
+	listRecursive bool
+	withDeps      bool
+
+	fromLayer      string
+	includeDeleted bool
+)
+
+func addExtractFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&withDeps, "with-deps", "", false, "Also extract the interpreter and shared libraries of ELF binaries, keeping their paths like in a sysroot")
+}
+
+func newExtractCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract <image> <path>...",
+		Short: "Extract files or directories from an image",
+		Args:  cobra.MinimumNArgs(2),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			return extractFiles(ctx, args[0], args[1:], outputDir)
+		}),
+	}
+	cmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory to extract the files to")
+	addExtractFlags(cmd)
+	return cmd
+}
+
+func newLsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ls <image> [path]",
+		Short: "List the files of an image",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			dir := "/"
+			if len(args) == 2 {
+				dir = args[1]
+			}
+
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			img, closeImage, err := pullImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			entry, err := img.Resolve(dir)
+			if err != nil {
+				return fail(err, "Can't list %s", dir)
+			}
+
+			entries := []*tar.Entry{entry}
+			if entry.IsDir() {
+				entries = img.Entries(entry.Path, listRecursive)
+			}
+			fs := img.FS()
+
+			if setResult(listResult(fs.Index(), entries)) {
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+			for _, e := range entries {
+				printEntry(w, fs.Index(), e)
+			}
+			w.Flush()
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVarP(&listRecursive, "recursive", "r", false, "List subdirectories recursively")
+	return cmd
+}
+
+func newCatCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cat <image> <path>",
+		Short: "Print the content of a file of an image",
+		Args:  cobra.ExactArgs(2),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			img, closeImage, err := pullImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			entry, err := img.Resolve(args[1])
+			if err != nil {
+				return fail(err, "Can't read %s", args[1])
+			}
+
+			rc, err := img.FS().Open(ctx, entry)
+			if err != nil {
+				return fail(err, "Can't read %s", args[1])
+			}
+			defer rc.Close()
+
+			if jsonOutput {
+				content, err := ioutil.ReadAll(rc)
+				if err != nil {
+					return fail(err, "Failed to read %s", args[1])
+				}
+				setResult(catResult{Path: entry.Path, Size: int64(len(content)), Content: content})
+				return nil
+			}
+
+			if _, err := io.Copy(os.Stdout, rc); err != nil {
+				return fail(err, "Failed to read %s", args[1])
+			}
+			return nil
+		}),
+	}
+}
+
+// fileEntry is an entry listed by ls in --json output
+type fileEntry struct {
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Mode     string    `json:"mode"`
+	UID      int       `json:"uid"`
+	GID      int       `json:"gid"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Linkname string    `json:"linkname,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+}
+
+type catResult struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Content []byte `json:"content"`
+}
+
+func listResult(index *tar.Index, entries []*tar.Entry) []fileEntry {
+	result := []fileEntry{}
+	for _, e := range entries {
+		entry := newFileEntry(e.Path, e.Header)
+		current, ok := index.Lookup(e.Path)
+		entry.Deleted = !ok || current != e
+		result = append(result, entry)
+	}
+	return result
+}
+
+func printEntry(w io.Writer, index *tar.Index, e *tar.Entry) {
+	h := e.Header
+	name := e.Path
+	if e.IsSymlink() {
+		name += " -> " + h.Linkname
+	}
+	if current, ok := index.Lookup(e.Path); !ok || current != e {
+		name += " (deleted)"
+	}
+	fmt.Fprintf(w, "%s\t%d/%d\t%d\t%s\t%s\n", h.FileInfo().Mode(), h.Uid, h.Gid, h.Size, h.ModTime.UTC().Format("2006-01-02 15:04"), name)
+}
+
+// extractFiles pulls the image and writes the files or directories at paths into dir
+func extractFiles(ctx context.Context, reference string, paths []string, dir string) error {
+	opts, err := dianaOptions()
+	if err != nil {
+		return err
+	}
+	opts.OutputDir = dir
+
+	img, closeImage, err := pullImage(ctx, reference, opts)
+	if err != nil {
+		return err
+	}
+	defer closeImage()
+
+	result, err := img.Extract(ctx, paths)
+	currentReport.Files = append(currentReport.Files, result.Files...)
+	if err != nil {
+		return fail(err, "Failed to extract files from %s", reference)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/cedrickring/diana/pkg/tar"
+	"github.com/cedrickring/diana/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <image> [path]",
+		Short: "List the layers of an image or every version of a path across the layers",
+		Long: "List the layers of an image or every version of a path across the layers.\n" +
+			"A version can be extracted with --from-layer, deleted files with --include-deleted.",
+		Args: cobra.RangeArgs(1, 2),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			if len(args) == 1 {
+				img, closeImage, err := openImage(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				defer closeImage()
+
+				history, err := layerHistory(ctx, img)
+				if err != nil {
+					return err
+				}
+				if setResult(layersResult(img, history)) {
+					return nil
+				}
+				printLayers(img, history)
+				return nil
+			}
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			//layer indexes have to match the ones of the manifest
+			opts.IncludeBaseLayer = true
+			opts.FromLayer = ""
+
+			fs, closeImage, err := loadImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			history, err := layerHistory(ctx, fs.Image())
+			if err != nil {
+				return err
+			}
+			versions := fs.Index().Versions(args[1])
+			if len(versions) == 0 {
+				return fail(errors.Wrap(os.ErrNotExist, args[1]), "The path doesn't exist in any layer")
+			}
+
+			var results []versionResult
+			for n, v := range versions {
+				results = append(results, versionResult{
+					Layer:       v.Layer,
+					LayerDigest: fs.Layers()[v.Layer].Digest,
+					Change:      versionChange(versions, n),
+					Mode:        v.Entry.Header.FileInfo().Mode().String(),
+					Size:        v.Entry.Header.Size,
+					CreatedBy:   history[v.Layer].CreatedBy,
+				})
+			}
+			if setResult(results) {
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "LAYER\tDIGEST\tCHANGE\tMODE\tSIZE\tCREATED BY")
+			for n, v := range versions {
+				change := versionChange(versions, n)
+
+				h := v.Entry.Header
+				size := util.FormatSize(h.Size)
+				if v.Deleted {
+					size = "-"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", v.Layer, shortDigest(fs.Layers()[v.Layer].Digest), change, h.FileInfo().Mode(), size, truncate(history[v.Layer].CreatedBy, 60))
+			}
+			w.Flush()
+			return nil
+		}),
+	}
+}
+
+type layerResult struct {
+	Layer     int    `json:"layer"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+type versionResult struct {
+	Layer       int    `json:"layer"`
+	LayerDigest string `json:"layerDigest"`
+	Change      string `json:"change"`
+	Mode        string `json:"mode"`
+	Size        int64  `json:"size"`
+	CreatedBy   string `json:"createdBy,omitempty"`
+}
+
+// versionChange describes how the n-th version changed the path
+func versionChange(versions []tar.Version, n int) string {
+	switch {
+	case versions[n].Deleted:
+		return "deleted"
+	case n > 0 && !versions[n-1].Deleted:
+		return "modified"
+	}
+	return "added"
+}
+
+func layersResult(img source.Image, history []registry.History) []layerResult {
+	results := []layerResult{}
+	for i, layer := range img.Manifest().Layers {
+		results = append(results, layerResult{Layer: i, Digest: layer.Digest, Size: layer.Size, CreatedBy: history[i].CreatedBy})
+	}
+	return results
+}
+
+func printLayers(img source.Image, history []registry.History) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tDIGEST\tSIZE\tCREATED BY")
+	for i, layer := range img.Manifest().Layers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i, shortDigest(layer.Digest), util.FormatSize(layer.Size), truncate(history[i].CreatedBy, 60))
+	}
+	w.Flush()
+}
+
+// layerHistory returns the history of every layer of the image, which is empty if the image
+// doesn't have any
+func layerHistory(ctx context.Context, img source.Image) ([]registry.History, error) {
+	layers := len(img.Manifest().Layers)
+
+	raw, err := img.Config(ctx)
+	if err == source.ErrNoConfig {
+		return make([]registry.History, layers), nil
+	} else if err != nil {
+		return nil, fail(err, "Failed to get image config")
+	}
+
+	config, err := registry.ParseImageConfig(raw)
+	if err != nil {
+		return nil, fail(err, "Failed to parse image config")
+	}
+	return config.LayerHistory(layers), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/progress"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/sirupsen/logrus"
+)
+
+func imagePlatform() (registry.Platform, error) {
+	if platform == "" {
+		return registry.DefaultPlatform(), nil
+	}
+
+	p, err := registry.ParsePlatform(platform)
+	if err != nil {
+		return registry.Platform{}, failf("Invalid platform: %v", err)
+	}
+	return p, nil
+}
+
+var (
+	noProgress bool
+	tracker    *progress.Tracker
+)
+
+// progressTracker returns the tracker of layer downloads, drawing bars if stderr is a terminal
+func progressTracker() *progress.Tracker {
+	if noProgress {
+		return nil
+	}
+
+	if tracker == nil {
+		if isTerminal(os.Stderr) {
+			tracker = progress.NewBars(os.Stderr)
+			logrus.SetOutput(tracker.Writer(logrus.StandardLogger().Out))
+		} else {
+			tracker = progress.NewLogger(10 * time.Second)
+		}
+	}
+	return tracker
+}
+
+// finishProgress stops drawing progress bars, the download summary is logged for registry images
+func finishProgress(img source.Image) {
+	if tracker == nil {
+		return
+	}
+
+	stats := tracker.Finish()
+	if img != nil && img.Reference().Transport == source.TransportDocker {
+		logrus.Info(stats)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// dianaOptions returns the options to open images with according to the global flags
+func dianaOptions() (diana.Options, error) {
+	p, err := imagePlatform()
+	if err != nil {
+		return diana.Options{}, err
+	}
+
+	opts := diana.Options{
+		Platform:            p,
+		Credentials:         registryCredentials,
+		Mirrors:             registryMirrors(),
+		Concurrency:         concurrency,
+		IncludeBaseLayer:    includeBaseLayer,
+		FromLayer:           fromLayer,
+		IncludeDeleted:      includeDeleted,
+		WithDeps:            withDeps,
+		ContainerdRoot:      containerdRoot,
+		ContainerdNamespace: containerdNamespace,
+	}
+
+	switch imageSource {
+	case "registry":
+		opts.DefaultTransport = source.TransportDocker
+	case "daemon":
+		opts.DefaultTransport = source.TransportDaemon
+	default:
+		return opts, failf("Unknown source %s, expected registry or daemon", imageSource)
+	}
+
+	transport, err := registry.NewTransport(registry.TransportOptions{
+		InsecureRegistries: insecureRegistries,
+		CAFile:             caFile,
+		CertFile:           certFile,
+		KeyFile:            keyFile,
+		RegistryCAFiles:    registryCAFiles(),
+	})
+	if err != nil {
+		return opts, fail(err, "Invalid TLS configuration")
+	}
+	opts.Transport = transport
+
+	if useCache {
+		if opts.Cache, err = openCache(); err != nil {
+			return opts, err
+		}
+	}
+	if tracker := progressTracker(); tracker != nil {
+		opts.Progress = tracker
+	}
+
+	return opts, nil
+}
+
+// openImage opens the image referenced with an optional transport prefix without pulling its
+// layers, the returned function releases it
+func openImage(ctx context.Context, reference string) (source.Image, func(), error) {
+	options, err := dianaOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := options.SourceOptions()
+	img, err := source.Open(ctx, reference, opts)
+	if err != nil {
+		return nil, nil, fail(err, "Failed to open image %s", reference)
+	}
+	reportImage(diana.Describe(img, opts.Platform))
+
+	return img, func() {
+		finishProgress(nil)
+		if err := img.Close(); err != nil {
+			logrus.WithError(err).Warnf("Failed to clean up image %s", reference)
+		}
+	}, nil
+}
+
+// pullImage opens the image and merges its layers, with --dry-run only the plan is printed
+func pullImage(ctx context.Context, reference string, opts diana.Options) (*diana.Image, func(), error) {
+	if dryRun {
+		if err := planImages(ctx, &opts, reference); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errDryRun
+	}
+
+	img, err := diana.Open(ctx, reference, opts)
+	if _, ok := err.(*diana.InvalidLayerError); ok {
+		finishProgress(nil)
+		return nil, nil, failf("Invalid layer: %v", err)
+	} else if err != nil {
+		finishProgress(nil)
+		return nil, nil, fail(err, "Failed to pull image %s", reference)
+	}
+	finishProgress(img.FS().Image())
+	reportImage(img.Info())
+	reportLayers(img.Layers())
+
+	return img, func() {
+		if err := img.Close(); err != nil {
+			logrus.WithError(err).Warnf("Failed to clean up image %s", reference)
+		}
+	}, nil
+}
+
+// loadImage is like pullImage for commands working on the merged filesystem directly
+func loadImage(ctx context.Context, reference string, opts diana.Options) (*rootfs.FS, func(), error) {
+	img, closeImage, err := pullImage(ctx, reference, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img.FS(), closeImage, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// lockFile is the content of the lockfile, e.g. diana.lock for diana.yaml
+type lockFile struct {
+	Images []lockedImage `json:"images"`
+}
+
+// lockedImage pins an image of the sync file for a platform
+type lockedImage struct {
+	Image    string `json:"image"`
+	Platform string `json:"platform"`
+	// Digest is the digest of the manifest for the platform
+	Digest string `json:"digest"`
+	// Files are the hex encoded sha256 checksums of the regular files keyed by their path in the image
+	Files map[string]string `json:"files"`
+}
+
+func newLockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Pin the images of a sync file to their digests and the checksums of their files",
+		Long: `Resolve the images of a sync file to the digests of their manifests for each platform and
+write them with the checksums of all listed files to a lockfile next to the sync file, e.g.
+diana.lock for diana.yaml. diana sync pulls the locked digests and verifies the extracted files.
+Run diana lock again to update the images.`,
+		Args: cobra.NoArgs,
+		Run: run(func(*cobra.Command, []string) error {
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			return runLock(ctx)
+		}),
+	}
+	cmd.Flags().StringVarP(&syncFile, "file", "f", "diana.yaml", "Sync file listing the images and files to lock")
+	return cmd
+}
+
+func runLock(ctx context.Context) error {
+	entries, err := readSyncFile(syncFile)
+	if err != nil {
+		return failf("Invalid sync file %s: %v", syncFile, err)
+	}
+
+	opts, cleanup, err := syncOptions()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	l := &locker{
+		syncer: syncer{opts: opts},
+		images: map[int]*lockedImage{},
+	}
+	l.run(ctx, entries, l.lockImage)
+	finishProgress(nil)
+
+	if l.failed {
+		return l.failure("Not writing %s as not all images could be locked", lockFilePath(syncFile))
+	}
+
+	//the images are written in the order of the sync file
+	lock := &lockFile{Images: []lockedImage{}}
+	for i := range entries {
+		if image, ok := l.images[i]; ok {
+			lock.Images = append(lock.Images, *image)
+		}
+	}
+	if err := writeLockFile(lockFilePath(syncFile), lock); err != nil {
+		return fail(err, "Failed to write %s", lockFilePath(syncFile))
+	}
+	logrus.Infof("Locked %d images in %s", len(lock.Images), lockFilePath(syncFile))
+
+	setResult(lock)
+	return nil
+}
+
+type locker struct {
+	syncer
+
+	//images are keyed by the index of the first entry of the image in the sync file
+	images map[int]*lockedImage
+	failed bool
+}
+
+// lockImage resolves the digest of the image and computes the checksums of the files of all its
+// entries by extracting them to a temporary directory
+func (l *locker) lockImage(ctx context.Context, entries []syncEntry, group []int) {
+	first := entries[group[0]]
+	opts := l.opts
+	opts.Platform = l.platform(first)
+
+	image, err := l.lock(ctx, first.Image, opts, entries, group)
+	if err != nil {
+		l.logError(err, "Failed to lock %s", first.Image)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.failed = true
+		return
+	}
+	l.images[group[0]] = image
+}
+
+func (l *locker) lock(ctx context.Context, reference string, opts diana.Options, entries []syncEntry, group []int) (*lockedImage, error) {
+	f, err := diana.OpenFS(ctx, reference, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir, err := ioutil.TempDir("", "diana-lock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	image := &lockedImage{
+		Image:    reference,
+		Platform: opts.Platform.String(),
+		Digest:   imageDigest(f.Info()),
+		Files:    map[string]string{},
+	}
+	logrus.Infof("Locking %s (%s) to %s", reference, image.Platform, image.Digest)
+
+	n := 0
+	for _, i := range group {
+		for _, p := range entries[i].Files {
+			files, err := f.ExtractTo(ctx, p.Path, filepath.Join(dir, strconv.Itoa(n)))
+			if err != nil {
+				return nil, err
+			}
+			n++
+
+			for _, file := range files {
+				if file.SHA256 != "" {
+					image.Files[file.Path] = file.SHA256
+				}
+			}
+		}
+	}
+	return image, nil
+}
+
+// lockFilePath returns the path of the lockfile of the sync file, diana.lock for diana.yaml
+func lockFilePath(syncFile string) string {
+	return strings.TrimSuffix(syncFile, filepath.Ext(syncFile)) + ".lock"
+}
+
+// readLockFile reads the lockfile, which is nil if it doesn't exist
+func readLockFile(file string) (*lockFile, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lock := &lockFile{}
+	if err := json.Unmarshal(b, lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func writeLockFile(file string, lock *lockFile) error {
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(b, '\n'), 0644)
+}
+
+// find returns the locked image, nil if the image isn't locked for the platform
+func (l *lockFile) find(image, platform string) *lockedImage {
+	for i := range l.Images {
+		if l.Images[i].Image == image && l.Images[i].Platform == platform {
+			return &l.Images[i]
+		}
+	}
+	return nil
+}
+
+// verify checks the checksums of the extracted files against the lockfile
+func (l *lockedImage) verify(files []diana.File) error {
+	for _, file := range files {
+		if file.SHA256 == "" {
+			continue
+		}
+
+		locked, ok := l.Files[file.Path]
+		if !ok {
+			return errors.Errorf("%s isn't locked, run diana lock", file.Path)
+		}
+		if locked != file.SHA256 {
+			err := &registry.DigestMismatchError{Expected: "sha256:" + locked, Actual: "sha256:" + file.SHA256}
+			return errors.Wrapf(err, "verifying %s", file.Path)
+		}
+	}
+	return nil
+}
+
+// pinReference returns the reference of the image by its digest. Only registry images can be
+// pulled by digest, other references are returned as they are.
+func pinReference(reference string, digest string, defaultTransport string) string {
+	ref, err := source.ParseReference(reference, defaultTransport)
+	if err != nil || ref.Transport != source.TransportDocker || strings.Contains(ref.Name, "@") {
+		return reference
+	}
+	return reference + "@" + digest
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/source"
+)
+
+func TestLockedImageVerify(t *testing.T) {
+	locked := &lockedImage{Files: map[string]string{
+		"/etc/hello": "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+	}}
+
+	tests := []struct {
+		name  string
+		files []diana.File
+		code  string
+	}{
+		{name: "matching checksum", files: []diana.File{{Path: "/etc/hello", SHA256: "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"}}},
+		{name: "directories aren't checked", files: []diana.File{{Path: "/etc", Type: "directory"}, {Path: "/usr", Type: "directory"}}},
+		{name: "other checksum", files: []diana.File{{Path: "/etc/hello", SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}, code: codeIntegrity},
+		{name: "file not in lockfile", files: []diana.File{{Path: "/etc/other", SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}, code: codeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := locked.verify(tt.files)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected verification to fail")
+			}
+			if code := errorCode(err); code != tt.code {
+				t.Errorf("expected %s, got %s for %v", tt.code, code, err)
+			}
+		})
+	}
+}
+
+func TestPinReference(t *testing.T) {
+	digest := "sha256:bc74621e18df78a5ce7d6b433d66cdecfa792a532d13b25697dcf2ce7154410c"
+
+	tests := []struct {
+		reference string
+		transport string
+		expected  string
+	}{
+		{reference: "alpine:3.18", transport: source.TransportDocker, expected: "alpine:3.18@" + digest},
+		{reference: "docker://alpine", transport: source.TransportDaemon, expected: "docker://alpine@" + digest},
+		{reference: "alpine@" + digest, transport: source.TransportDocker, expected: "alpine@" + digest},
+		{reference: "alpine", transport: source.TransportDaemon, expected: "alpine"},
+		{reference: "oci:layout:latest", transport: source.TransportDocker, expected: "oci:layout:latest"},
+	}
+	for _, tt := range tests {
+		if pinned := pinReference(tt.reference, digest, tt.transport); pinned != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.reference, tt.expected, pinned)
+		}
+	}
+}
+
+func TestLockFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	file := lockFilePath(filepath.Join(dir, "diana.yaml"))
+	if file != filepath.Join(dir, "diana.lock") {
+		t.Fatalf("unexpected lockfile path %s", file)
+	}
+
+	if lock, err := readLockFile(file); err != nil || lock != nil {
+		t.Fatalf("expected no lockfile, got %v, %v", lock, err)
+	}
+
+	lock := &lockFile{Images: []lockedImage{{
+		Image:    "alpine:3.18",
+		Platform: "linux/arm64",
+		Digest:   "sha256:bc74621e18df78a5ce7d6b433d66cdecfa792a532d13b25697dcf2ce7154410c",
+		Files:    map[string]string{"/etc/hello": "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"},
+	}}}
+	if err := writeLockFile(file, lock); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readLockFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := read.find("alpine:3.18", "linux/arm64"); image == nil || image.Digest != lock.Images[0].Digest {
+		t.Errorf("expected to find the locked image, got %v", image)
+	}
+	if image := read.find("alpine:3.18", "linux/amd64"); image != nil {
+		t.Errorf("expected other platforms not to be locked, got %v", image)
+	}
+}
+
+func TestSyncVerifiesLockedDigest(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.push(t, "app", "latest", map[string]string{"etc/hello": "hello"})
+	reg.push(t, "app", "evil", map[string]string{"etc/hello": "evil"})
+
+	opts := reg.options()
+	entries := []syncEntry{{Image: reg.host + "/app:latest", Files: []syncPath{{Path: "/etc/hello"}}}}
+
+	l := &locker{syncer: syncer{opts: opts}, images: map[int]*lockedImage{}}
+	l.run(context.Background(), entries, l.lockImage)
+	if l.failed {
+		t.Fatal("expected the image to be locked")
+	}
+	lock := &lockFile{Images: []lockedImage{*l.images[0]}}
+
+	syncImage := func() syncResult {
+		s := &syncer{
+			opts:    opts,
+			baseDir: t.TempDir(),
+			state:   &syncState{},
+			lock:    lock,
+			results: make([]syncResult, len(entries)),
+			records: make([]*syncRecord, len(entries)),
+		}
+		s.run(context.Background(), entries, s.syncImage)
+		return s.results[0]
+	}
+
+	if result := syncImage(); result.Status != syncExtracted || result.Digest != lock.Images[0].Digest {
+		t.Fatalf("expected the locked image to be extracted, got %+v", result)
+	}
+
+	//the tag moved and the registry serves another manifest under the locked digest
+	reg.serve("app", "latest", "evil")
+	reg.serve("app", lock.Images[0].Digest, "evil")
+	if result := syncImage(); result.Status != syncFailed {
+		t.Errorf("expected a tampered manifest to fail the sync, got %+v", result)
+	}
+
+	_, err := diana.OpenFS(context.Background(), pinReference(entries[0].Image, lock.Images[0].Digest, source.TransportDocker), opts)
+	if code := errorCode(err); code != codeIntegrity {
+		t.Errorf("expected %s, got %s for %v", codeIntegrity, code, err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Stable error codes of --json output
+const (
+	codeInvalidArgument = "INVALID_ARGUMENT"
+	codeImageNotFound   = "IMAGE_NOT_FOUND"
+	codeFileNotFound    = "FILE_NOT_FOUND"
+	codeAuthFailed      = "AUTH_FAILED"
+	codeNetwork         = "NETWORK_ERROR"
+	codeIntegrity       = "INTEGRITY_ERROR"
+	codeTimeout         = "TIMEOUT"
+	codeInternal        = "INTERNAL"
+)
+
+// exitCodes maps the error codes to the exit codes of diana, other errors exit with 1
+var exitCodes = map[string]int{
+	codeInvalidArgument: 2,
+	codeImageNotFound:   3,
+	codeFileNotFound:    3,
+	codeAuthFailed:      4,
+	codeNetwork:         5,
+	codeTimeout:         5,
+	codeIntegrity:       6,
+	codeInternal:        1,
+}
+
+var jsonOutput bool
+
+// report is the result document of a command printed with --json
+type report struct {
+	Command string            `json:"command"`
+	Image   *diana.ImageInfo  `json:"image,omitempty"`
+	Layers  []diana.LayerInfo `json:"layers,omitempty"`
+	Files   []diana.File      `json:"files,omitempty"`
+	// Result holds the command specific output, like the entries listed by ls
+	Result interface{}  `json:"result,omitempty"`
+	Error  *errorReport `json:"error,omitempty"`
+}
+
+type errorReport struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var currentReport = &report{}
+
+// printReport writes the report to stdout, it's a no-op without --json
+func printReport() {
+	if !jsonOutput {
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(currentReport)
+}
+
+// exit prints the report before exiting, the code is replaced by the one of the reported error
+func exit(code int) {
+	printReport()
+	if currentReport.Error != nil {
+		code = exitCodes[currentReport.Error.Code]
+	}
+	os.Exit(code)
+}
+
+// commandError is returned up to the command instead of exiting right away, so deferred cleanup
+// like removing temporary layers runs before diana exits with the code of the error
+type commandError struct {
+	message string
+	err     error
+}
+
+func (e *commandError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return e.message + ": " + e.err.Error()
+}
+
+// fail wraps the error with the message logged for it
+func fail(err error, format string, args ...interface{}) error {
+	return &commandError{message: fmt.Sprintf(format, args...), err: err}
+}
+
+// failf returns an error caused by invalid arguments or flags
+func failf(format string, args ...interface{}) error {
+	return &commandError{message: fmt.Sprintf(format, args...)}
+}
+
+// exitStatus makes a command exit with the status without logging an error
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// errDryRun stops commands once the plan of --dry-run has been printed
+var errDryRun = errors.New("dry run")
+
+// run adapts a command returning errors to cobra. The error is reported once the command and its
+// deferred functions are done, finishReport exits with its code afterwards.
+func run(fn func(cmd *cobra.Command, args []string) error) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := fn(cmd, args); err != nil {
+			reportError(err)
+		}
+	}
+}
+
+// reportError logs the error of the command and makes it the error of the report, which
+// determines the exit code
+func reportError(err error) {
+	switch e := err.(type) {
+	case exitStatus:
+		exit(int(e))
+	case *commandError:
+		if e.err == nil {
+			logrus.Error(e.message)
+		} else {
+			logrus.WithError(e.err).Error(e.message)
+		}
+		currentReport.Error = &errorReport{Code: errorCode(e.err), Message: e.Error()}
+	default:
+		if err == errDryRun {
+			return
+		}
+		logrus.WithError(err).Error("Command failed")
+		currentReport.Error = &errorReport{Code: errorCode(err), Message: "Command failed: " + err.Error()}
+	}
+}
+
+// finishReport prints the report at the end of a command, exiting with the code of the reported
+// error if there's one
+func finishReport() {
+	if currentReport.Error != nil {
+		exit(1)
+	}
+	printReport()
+}
+
+// setResult sets the command specific result, it returns whether --json is used so callers
+// can skip their text output
+func setResult(result interface{}) bool {
+	currentReport.Result = result
+	return jsonOutput
+}
+
+// reportImage sets the image of the report, commands comparing images report the first one
+func reportImage(info diana.ImageInfo) {
+	if currentReport.Image == nil {
+		currentReport.Image = &info
+	}
+}
+
+func reportLayers(layers []diana.LayerInfo) {
+	if currentReport.Layers == nil {
+		currentReport.Layers = layers
+	}
+}
+
+// errorCode classifies the error, commands failing without error value were given invalid
+// arguments or flags
+func errorCode(err error) string {
+	if err == nil {
+		return codeInvalidArgument
+	}
+
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		if urlErr.Timeout() {
+			return codeTimeout
+		}
+		cause = errors.Cause(urlErr.Err)
+	}
+
+	switch cause {
+	case registry.ErrNotFound:
+		return codeImageNotFound
+	case registry.ErrAuthRequired:
+		return codeAuthFailed
+	case source.ErrInvalidReference:
+		return codeInvalidArgument
+	case context.DeadlineExceeded:
+		return codeTimeout
+	}
+
+	switch e := cause.(type) {
+	case *registry.DigestMismatchError:
+		return codeIntegrity
+	case *diana.InvalidLayerError:
+		return codeInvalidArgument
+	case net.Error:
+		if e.Timeout() {
+			return codeTimeout
+		}
+		return codeNetwork
+	}
+
+	if os.IsNotExist(cause) {
+		return codeFileNotFound
+	}
+	return codeInternal
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func TestReportError(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{name: "invalid argument", err: failf("Please specify a file"), code: codeInvalidArgument},
+		{name: "missing file", err: fail(os.ErrNotExist, "Failed to extract"), code: codeFileNotFound},
+		{name: "digest mismatch", err: fail(errors.Wrap(&registry.DigestMismatchError{}, "pulling layer"), "Failed to pull"), code: codeIntegrity},
+		{name: "other error", err: errors.New("broken"), code: codeInternal},
+		{name: "dry run", err: errDryRun},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currentReport = &report{}
+			reportError(tt.err)
+
+			if tt.code == "" {
+				if currentReport.Error != nil {
+					t.Errorf("expected no error, got %v", currentReport.Error)
+				}
+				return
+			}
+			if currentReport.Error == nil || currentReport.Error.Code != tt.code {
+				t.Errorf("expected %s, got %v", tt.code, currentReport.Error)
+			}
+		})
+	}
+
+	//errors logged while the command continues don't fail it
+	currentReport = &report{}
+	logrus.Error("Failed to read a file, skipping it")
+	if currentReport.Error != nil {
+		t.Errorf("expected logged errors not to be reported, got %v", currentReport.Error)
+	}
+}
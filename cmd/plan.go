@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cedrickring/diana/pkg/cache"
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/cedrickring/diana/pkg/util"
+)
+
+var dryRun bool
+
+// Actions of a layer in a plan
+const (
+	actionPull   = "pull"
+	actionCached = "cached"
+	actionLocal  = "local"
+	actionSkip   = "skip"
+)
+
+// imagePlan is what --dry-run prints instead of pulling the image
+type imagePlan struct {
+	Reference  string      `json:"reference"`
+	Digest     string      `json:"digest,omitempty"`
+	Config     string      `json:"config,omitempty"`
+	ConfigSize int64       `json:"configSize"`
+	Layers     []layerPlan `json:"layers"`
+	// Download is the number of bytes pulled from the registry
+	Download int64 `json:"download"`
+	Cached   int64 `json:"cached"`
+	Local    int64 `json:"local"`
+	Skipped  int64 `json:"skipped"`
+}
+
+type layerPlan struct {
+	Index  int    `json:"index"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Action string `json:"action"`
+}
+
+// planImages prints which layers of the images would be pulled without downloading them. The
+// options select the layers needed, every layer is needed if they're nil.
+func planImages(ctx context.Context, opts *diana.Options, references ...string) error {
+	var c *cache.Cache
+	if useCache {
+		var err error
+		if c, err = openCache(); err != nil {
+			return err
+		}
+	}
+
+	var plans []imagePlan
+	for _, reference := range references {
+		plan, err := planImage(ctx, reference, opts, c)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, plan)
+	}
+
+	if setResult(plans) {
+		return nil
+	}
+	for _, plan := range plans {
+		printPlan(plan)
+	}
+	return nil
+}
+
+func planImage(ctx context.Context, reference string, opts *diana.Options, c *cache.Cache) (imagePlan, error) {
+	img, closeImage, err := openImage(ctx, reference)
+	if err != nil {
+		return imagePlan{}, err
+	}
+	defer closeImage()
+
+	manifest := img.Manifest()
+	plan := imagePlan{
+		Reference: img.Reference().String(),
+		Digest:    manifest.Digest,
+		Config:    manifest.Config.Digest,
+	}
+
+	config, err := img.Config(ctx)
+	if err != nil && err != source.ErrNoConfig {
+		return imagePlan{}, fail(err, "Failed to get image config")
+	}
+	plan.ConfigSize = int64(len(config))
+
+	needed := manifest.Layers
+	if opts != nil {
+		needed, err = opts.SelectLayers(manifest.Layers)
+		if err != nil {
+			return imagePlan{}, failf("Invalid layer: %v", err)
+		}
+	}
+
+	for i, layer := range manifest.Layers {
+		action := layerAction(img, layer, needed, c)
+		switch action {
+		case actionPull:
+			plan.Download += layer.Size
+		case actionCached:
+			plan.Cached += layer.Size
+		case actionLocal:
+			plan.Local += layer.Size
+		case actionSkip:
+			plan.Skipped += layer.Size
+		}
+		plan.Layers = append(plan.Layers, layerPlan{Index: i, Digest: layer.Digest, Size: layer.Size, Action: action})
+	}
+
+	return plan, nil
+}
+
+func layerAction(img source.Image, layer registry.Layer, needed []registry.Layer, c *cache.Cache) string {
+	for _, n := range needed {
+		if n.Digest != layer.Digest {
+			continue
+		}
+
+		switch {
+		case img.Reference().Transport != source.TransportDocker:
+			return actionLocal
+		case c != nil && c.Contains(layer.Digest):
+			return actionCached
+		}
+		return actionPull
+	}
+	return actionSkip
+}
+
+func printPlan(plan imagePlan) {
+	fmt.Printf("Image %s", plan.Reference)
+	if plan.Digest != "" {
+		fmt.Printf(" (%s)", plan.Digest)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tDIGEST\tSIZE\tACTION")
+	for _, layer := range plan.Layers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", layer.Index, shortDigest(layer.Digest), util.FormatSize(layer.Size), layer.Action)
+	}
+	w.Flush()
+
+	summary := "Would download " + util.FormatSize(plan.Download)
+	if plan.Cached > 0 {
+		summary += ", reuse " + util.FormatSize(plan.Cached) + " from the cache"
+	}
+	if plan.Local > 0 {
+		summary += ", read " + util.FormatSize(plan.Local) + " locally"
+	}
+	fmt.Printf("%s and skip %s\n\n", summary, util.FormatSize(plan.Skipped))
+}
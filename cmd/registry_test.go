@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+)
+
+// testRegistry is a registry serving the images pushed to it over TLS
+type testRegistry struct {
+	host      string
+	transport http.RoundTripper
+
+	//delay is waited before manifests are served
+	delay time.Duration
+
+	mu sync.Mutex
+	//manifests are keyed by repository and tag or digest
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	requests  int
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+	}
+	srv := httptest.NewTLSServer(r)
+	t.Cleanup(srv.Close)
+
+	r.host = strings.TrimPrefix(srv.URL, "https://")
+	r.transport = srv.Client().Transport
+	return r
+}
+
+// options returns the options to pull images from the registry
+func (r *testRegistry) options() diana.Options {
+	return diana.Options{
+		Platform:         registry.DefaultPlatform(),
+		DefaultTransport: source.TransportDocker,
+		Credentials:      diana.Anonymous,
+		Transport:        r.transport,
+	}
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if strings.Contains(path, "/manifests/") {
+		time.Sleep(r.delay)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		r.requests++
+		manifest, ok := r.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", registry.MediaTypeOCIManifest)
+		w.Write(manifest)
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		blob, ok := r.blobs[path[i+len("/blobs/"):]]
+		if !ok {
+			http.Error(w, "blob unknown", http.StatusNotFound)
+			return
+		}
+		w.Write(blob)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// push adds an image for the default platform and returns the digest of its manifest, layers
+// map the paths of regular files to their content and are listed from the base layer up
+func (r *testRegistry) push(t *testing.T, repository, tag string, layers ...map[string]string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	put := func(content []byte) registry.Layer {
+		digest := registry.Digest(content)
+		r.blobs[digest] = content
+		return registry.Layer{Digest: digest, Size: int64(len(content))}
+	}
+
+	manifest := registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}
+	var diffIDs []string
+	for _, files := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for path, content := range files {
+			tw.WriteHeader(&tar.Header{Name: filepath.Dir(path) + "/", Typeflag: tar.TypeDir, Mode: 0755})
+			tw.WriteHeader(&tar.Header{Name: path, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: time.Unix(1600000000, 0)})
+			tw.Write([]byte(content))
+		}
+		tw.Close()
+
+		layer := put(buf.Bytes())
+		layer.MediaType = registry.MediaTypeOCILayer
+		manifest.Layers = append(manifest.Layers, layer)
+		diffIDs = append(diffIDs, layer.Digest)
+	}
+
+	platform := registry.DefaultPlatform()
+	config, _ := json.Marshal(map[string]interface{}{
+		"os":           platform.OS,
+		"architecture": platform.Architecture,
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	c := put(config)
+	manifest.Config = registry.ManifestConfig{MediaType: registry.MediaTypeOCIConfig, Digest: c.Digest, Size: c.Size}
+
+	m, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := registry.Digest(m)
+	r.manifests[repository+":"+tag] = m
+	r.manifests[repository+":"+digest] = m
+	return digest
+}
+
+// serve makes the registry serve the manifest of another reference of the repository
+func (r *testRegistry) serve(repository, reference, other string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifests[repository+":"+reference] = r.manifests[repository+":"+other]
+}
+
+// manifestRequests returns the number of manifests requested so far
+func (r *testRegistry) manifestRequests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
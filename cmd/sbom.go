@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/cedrickring/diana/pkg/sbom"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sbomFormat string
+	sbomOutput string
+)
+
+func newSBOMCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sbom <image>",
+		Short: "Create a software bill of materials from the package databases and binaries of an image",
+		Args:  cobra.ExactArgs(1),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			var write func(io.Writer, string, *sbom.Result) error
+			switch sbomFormat {
+			case "spdx-json":
+				write = sbom.WriteSPDX
+			case "cyclonedx-json":
+				write = sbom.WriteCycloneDX
+			default:
+				return failf("Unknown format %s, expected spdx-json or cyclonedx-json", sbomFormat)
+			}
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			//packages of the base image belong to the bill of materials as well
+			opts.IncludeBaseLayer = true
+
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			fs, closeImage, err := loadImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			result, err := sbom.Scan(ctx, fs)
+			if err != nil {
+				return fail(err, "Failed to scan image %s", args[0])
+			}
+			logrus.Infof("Found %d packages", len(result.Packages))
+
+			if jsonOutput && sbomOutput == "" {
+				var buf bytes.Buffer
+				if err := write(&buf, args[0], result); err != nil {
+					return fail(err, "Failed to write bill of materials")
+				}
+				setResult(json.RawMessage(buf.Bytes()))
+				return nil
+			}
+
+			out := os.Stdout
+			if sbomOutput != "" {
+				out, err = os.Create(sbomOutput)
+				if err != nil {
+					return fail(err, "Can't create %s", sbomOutput)
+				}
+				defer out.Close()
+			}
+
+			if err := write(out, args[0], result); err != nil {
+				return fail(err, "Failed to write bill of materials")
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&sbomFormat, "format", "", "spdx-json", "Output format: spdx-json or cyclonedx-json")
+	cmd.Flags().StringVarP(&sbomOutput, "output", "o", "", "File to write the bill of materials to, stdout if empty")
+	return cmd
+}
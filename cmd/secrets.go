@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretsRules     string
+	secretsNoDefault bool
+	secretsFormat    string
+)
+
+func newSecretsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets <image>",
+		Short: "Scan all layers of an image for secrets, including files deleted by later layers",
+		Long: "Scan all layers of an image for secrets, including files deleted by later layers.\n" +
+			"Exits with 1 if secrets were found.",
+		Args: cobra.ExactArgs(1),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			if secretsFormat != "text" && secretsFormat != "json" {
+				return failf("Unknown format %s, expected text or json", secretsFormat)
+			}
+
+			rules, err := secrets.LoadRules(secretsRules, !secretsNoDefault)
+			if err != nil {
+				return fail(err, "Failed to load rules")
+			}
+			if len(rules) == 0 {
+				return failf("No rules to scan with")
+			}
+
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			if dryRun {
+				//all layers are scanned
+				return planImages(ctx, nil, args[0])
+			}
+
+			img, closeImage, err := openImage(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			stopPrefetch := rootfs.Prefetch(ctx, img, img.Manifest().Layers, concurrency)
+			defer stopPrefetch()
+			findings, err := secrets.Scan(ctx, img, rules)
+			if err != nil {
+				return fail(err, "Failed to scan image %s", args[0])
+			}
+			finishProgress(img)
+
+			if findings == nil {
+				findings = []secrets.Finding{}
+			}
+			switch {
+			case setResult(findings):
+			case secretsFormat == "json":
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				enc.Encode(findings)
+			default:
+				printFindings(findings)
+			}
+
+			if len(findings) > 0 {
+				return exitStatus(1)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&secretsRules, "rules", "", "", "JSON file with additional rules, rules with the id of a built-in rule replace it")
+	cmd.Flags().BoolVarP(&secretsNoDefault, "no-default-rules", "", false, "Only scan with the rules of --rules")
+	cmd.Flags().StringVarP(&secretsFormat, "format", "", "text", "Output format: text or json")
+	return cmd
+}
+
+func printFindings(findings []secrets.Finding) {
+	if len(findings) == 0 {
+		logrus.Infof("No secrets found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tPATH\tRULE\tMATCH\tDELETED\tCREATED BY")
+	for _, f := range findings {
+		location := f.Path
+		if f.Line > 0 {
+			location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", shortDigest(f.LayerDigest), location, f.Rule, f.Match, f.Deleted, truncate(f.CreatedBy, 60))
+	}
+	w.Flush()
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return digest
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n-3] + "..."
+	}
+	return s
+}
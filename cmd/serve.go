@@ -0,0 +1,468 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// maxServedImages is the number of images kept open, so their layers don't have to be read again
+const maxServedImages = 32
+
+// resolveInterval is how long an opened image is served before its reference is resolved again,
+// so tags pushed in the meantime are picked up
+const resolveInterval = 30 * time.Second
+
+// openTimeout bounds resolving a reference, layers are pulled with the context of the requests
+const openTimeout = time.Minute
+
+var (
+	listenAddress      string
+	servedRepositories []string
+)
+
+// httpStatus maps the error codes to the status of responses
+var httpStatus = map[string]int{
+	codeInvalidArgument: http.StatusBadRequest,
+	codeImageNotFound:   http.StatusNotFound,
+	codeFileNotFound:    http.StatusNotFound,
+	codeAuthFailed:      http.StatusBadGateway,
+	codeNetwork:         http.StatusBadGateway,
+	codeIntegrity:       http.StatusBadGateway,
+	codeTimeout:         http.StatusGatewayTimeout,
+	codeInternal:        http.StatusInternalServerError,
+}
+
+func newServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the files of images over HTTP, pulling only the layers needed",
+		Long: `Serve the files of images over HTTP:
+
+  GET /v1/<image>/-/<path>   content of the file, supports Range requests
+  GET /v1/<image>/ls/<path>  JSON listing of the directory
+
+Use ?platform=os/arch to pick a platform of multi-platform images. Layers are always kept in the layer cache.
+References are resolved again at most every 30s, so updated tags are served shortly after a push.
+
+Only images of registries are served, other transports like docker-daemon: or oci: are rejected.
+Use --repository to serve only the images of some repositories.`,
+		Args: cobra.NoArgs,
+		Run: run(func(*cobra.Command, []string) error {
+			noProgress = true
+			useCache = true
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			s := &server{
+				opts:    opts,
+				images:  map[string]*servedImage{},
+				opening: map[string]*openingImage{},
+			}
+			if len(servedRepositories) > 0 {
+				s.repositories = map[string]bool{}
+				for _, r := range servedRepositories {
+					repo, err := name.NewRepository(r, name.WeakValidation)
+					if err != nil {
+						return failf("Invalid repository %s: %v", r, err)
+					}
+					s.repositories[repo.Name()] = true
+				}
+			}
+			srv := &http.Server{
+				Addr:              listenAddress,
+				Handler:           s,
+				ReadHeaderTimeout: 10 * time.Second,
+				ReadTimeout:       30 * time.Second,
+				IdleTimeout:       2 * time.Minute,
+				//no WriteTimeout, files are streamed while their layers are pulled
+			}
+			logrus.Infof("Listening on %s", listenAddress)
+			if err := srv.ListenAndServe(); err != nil {
+				return fail(err, "Failed to serve")
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&listenAddress, "listen", "", ":8080", "Address to listen on")
+	cmd.Flags().StringSliceVarP(&servedRepositories, "repository", "", nil, "Repositories to serve images of, all if not set")
+	return cmd
+}
+
+type server struct {
+	opts diana.Options
+	//repositories are the names of the repositories which are served, all if nil
+	repositories map[string]bool
+
+	mu      sync.Mutex
+	images  map[string]*servedImage  //keyed by reference and platform
+	opening map[string]*openingImage //keyed like images
+}
+
+// servedImage is an open image shared by all requests for it
+type servedImage struct {
+	key      string
+	fs       *diana.FS
+	users    int
+	lastUsed time.Time
+	//resolved is when the reference was last resolved to the image
+	resolved time.Time
+}
+
+// openingImage is a reference being resolved, the channel is closed once it's done
+type openingImage struct {
+	done chan struct{}
+	err  error
+}
+
+// listing is the response of ls requests
+type listing struct {
+	Image   diana.ImageInfo `json:"image"`
+	Path    string          `json:"path"`
+	Entries []fileEntry     `json:"entries"`
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		logrus.Infof("%s %s %d (%s)", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+	}()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(rec, http.StatusMethodNotAllowed, codeInvalidArgument, "only GET and HEAD are supported")
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case p == r.URL.Path:
+	case strings.Contains(p, "/-/"):
+		i := strings.Index(p, "/-/")
+		s.serveFile(rec, r, p[:i], p[i+len("/-/"):])
+		return
+	case strings.Contains(p, "/ls/"):
+		i := strings.Index(p, "/ls/")
+		s.serveListing(rec, r, p[:i], p[i+len("/ls/"):])
+		return
+	case strings.HasSuffix(p, "/ls"):
+		s.serveListing(rec, r, strings.TrimSuffix(p, "/ls"), "")
+		return
+	}
+	writeError(rec, http.StatusNotFound, codeInvalidArgument, "expected /v1/<image>/-/<path> or /v1/<image>/ls/<path>")
+}
+
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, reference, name string) {
+	img, err := s.acquire(r, reference)
+	if err != nil {
+		failRequest(w, err)
+		return
+	}
+	defer s.release(img)
+	fsys := img.fs.WithContext(r.Context())
+
+	name = fsName(name)
+	f, err := fsys.Open(name)
+	if err != nil {
+		failRequest(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		failRequest(w, err)
+		return
+	}
+	if info.IsDir() {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, name+" is a directory, use /ls/ to list it")
+		return
+	}
+
+	location, err := fsys.Locate(name)
+	if err != nil {
+		failRequest(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(location.Layer, location.Path))
+	w.Header().Set("X-Image-Digest", fsys.Info().Digest)
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "file isn't seekable")
+		return
+	}
+	http.ServeContent(w, r, info.Name(), modTime(info), content)
+}
+
+func (s *server) serveListing(w http.ResponseWriter, r *http.Request, reference, name string) {
+	img, err := s.acquire(r, reference)
+	if err != nil {
+		failRequest(w, err)
+		return
+	}
+	defer s.release(img)
+	fsys := img.fs.WithContext(r.Context())
+
+	name = fsName(name)
+	info := fsys.Info()
+	tag := etag(info.Digest, info.ID, name)
+	w.Header().Set("ETag", tag)
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	result := listing{Image: info, Path: path.Join("/", name), Entries: []fileEntry{}}
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		failRequest(w, err)
+		return
+	}
+	if !fi.IsDir() {
+		result.Entries = append(result.Entries, newFileEntry(result.Path, fi.Sys().(*tar.Header)))
+	} else {
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			failRequest(w, err)
+			return
+		}
+		for _, e := range entries {
+			fi, err := e.Info()
+			if err != nil {
+				failRequest(w, err)
+				return
+			}
+			result.Entries = append(result.Entries, newFileEntry(path.Join(result.Path, e.Name()), fi.Sys().(*tar.Header)))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
+
+// acquire returns the image for the request. Images are shared by all requests for the same
+// reference and platform, the reference is only resolved again once resolveInterval passed and
+// concurrent requests wait for the same resolution. The image has to be released once the
+// request is done.
+func (s *server) acquire(r *http.Request, reference string) (*servedImage, error) {
+	if err := s.checkReference(reference); err != nil {
+		return nil, &requestError{err}
+	}
+
+	opts := s.opts
+	if p := r.URL.Query().Get("platform"); p != "" {
+		platform, err := registry.ParsePlatform(p)
+		if err != nil {
+			return nil, &requestError{err}
+		}
+		opts.Platform = platform
+	}
+	key := reference + "@" + opts.Platform.String()
+
+	for {
+		s.mu.Lock()
+		if img, ok := s.images[key]; ok && time.Since(img.resolved) < resolveInterval {
+			s.use(img)
+			s.mu.Unlock()
+			return img, nil
+		}
+		o, ok := s.opening[key]
+		if !ok {
+			o = &openingImage{done: make(chan struct{})}
+			s.opening[key] = o
+			s.mu.Unlock()
+			return s.open(key, reference, opts, o)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-o.done:
+			if o.err != nil {
+				return nil, o.err
+			}
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+}
+
+// open resolves the reference and serves the image unless it's the one already served
+func (s *server) open(key, reference string, opts diana.Options, o *openingImage) (*servedImage, error) {
+	//the open isn't bound to the request, as other requests may be waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), openTimeout)
+	defer cancel()
+	f, err := diana.OpenFS(ctx, reference, opts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.opening, key)
+	o.err = err
+	close(o.done)
+	if err != nil {
+		return nil, err
+	}
+
+	img, ok := s.images[key]
+	if ok && sameImage(img.fs.Info(), f.Info()) {
+		f.Close()
+		img.resolved = time.Now()
+	} else {
+		if ok {
+			s.remove(img)
+		}
+		img = &servedImage{key: key, fs: f, resolved: time.Now()}
+		s.images[key] = img
+	}
+	s.use(img)
+	s.evict()
+	return img, nil
+}
+
+// checkReference makes sure only images of the served repositories are pulled, other transports
+// would expose the files and daemons of the host
+func (s *server) checkReference(reference string) error {
+	ref, err := source.ParseReference(reference, s.opts.DefaultTransport)
+	if err != nil {
+		return err
+	}
+	if ref.Transport != source.TransportDocker {
+		return errors.Errorf("%s images aren't served, only images of registries", ref.Transport)
+	}
+	imageRef, err := name.ParseReference(ref.Name, name.WeakValidation)
+	if err != nil {
+		return errors.Wrapf(source.ErrInvalidReference, "%s: %v", ref.Name, err)
+	}
+	if s.repositories != nil && !s.repositories[imageRef.Context().Name()] {
+		return errors.Errorf("images of %s aren't served", imageRef.Context().Name())
+	}
+	return nil
+}
+
+func (s *server) use(img *servedImage) {
+	img.users++
+	img.lastUsed = time.Now()
+}
+
+func (s *server) release(img *servedImage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	img.users--
+	if s.images[img.key] != img && img.users == 0 {
+		img.fs.Close()
+	}
+}
+
+// remove stops serving the image, it's closed once the last request using it is done
+func (s *server) remove(img *servedImage) {
+	delete(s.images, img.key)
+	if img.users == 0 {
+		img.fs.Close()
+	}
+}
+
+// evict closes the least recently used images which aren't in use while there are too many
+func (s *server) evict() {
+	for len(s.images) > maxServedImages {
+		var oldest *servedImage
+		for _, img := range s.images {
+			if oldest == nil || img.lastUsed.Before(oldest.lastUsed) {
+				oldest = img
+			}
+		}
+		s.remove(oldest)
+	}
+}
+
+// sameImage reports whether the reference still resolves to the same image
+func sameImage(a, b diana.ImageInfo) bool {
+	return a.Digest == b.Digest && a.ID == b.ID
+}
+
+// requestError is an error caused by the parameters of the request
+type requestError struct {
+	error
+}
+
+func failRequest(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	if _, ok := err.(*requestError); ok {
+		code = codeInvalidArgument
+	}
+	writeError(w, httpStatus[code], code, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorReport{Code: code, Message: message})
+}
+
+// fsName converts the path of the request to the name of a file in an fs.FS
+func fsName(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// etag derives a strong ETag from the parts identifying the content, like blob digests
+func etag(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// modTime returns the modification time of the file, layers built reproducibly use the epoch
+// which isn't worth a Last-Modified header
+func modTime(info fs.FileInfo) time.Time {
+	if info.ModTime().Unix() <= 0 {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func newFileEntry(p string, h *tar.Header) fileEntry {
+	return fileEntry{
+		Path:     p,
+		Type:     diana.FileType(h),
+		Mode:     h.FileInfo().Mode().String(),
+		UID:      h.Uid,
+		GID:      h.Gid,
+		Size:     h.Size,
+		ModTime:  h.ModTime.UTC(),
+		Linkname: h.Linkname,
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
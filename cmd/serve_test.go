@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/registry"
+)
+
+func newTestServer(t *testing.T) (*server, *httptest.Server, *testRegistry, string) {
+	reg := newTestRegistry(t)
+	reg.push(t, "test/app", "latest",
+		map[string]string{"etc/hello": "hello", "etc/base": "base"},
+		map[string]string{"etc/hello": "hello v2"},
+	)
+
+	s := &server{
+		opts:    reg.options(),
+		images:  map[string]*servedImage{},
+		opening: map[string]*openingImage{},
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv, reg, srv.URL + "/v1/" + reg.host + "/test/app:latest"
+}
+
+func get(t *testing.T, url string, header map[string]string) (*http.Response, string) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		request.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestServeFile(t *testing.T) {
+	_, _, _, image := newTestServer(t)
+
+	resp, body := get(t, image+"/-/etc/hello", nil)
+	if resp.StatusCode != http.StatusOK || body != "hello v2" {
+		t.Fatalf("expected 200 hello v2, got %d %q", resp.StatusCode, body)
+	}
+	tag := resp.Header.Get("ETag")
+	if tag == "" || resp.Header.Get("X-Image-Digest") == "" {
+		t.Errorf("expected ETag and X-Image-Digest headers, got %v", resp.Header)
+	}
+
+	resp, body = get(t, image+"/-/etc/hello", map[string]string{"Range": "bytes=0-4"})
+	if resp.StatusCode != http.StatusPartialContent || body != "hello" {
+		t.Errorf("expected 206 hello, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, _ = get(t, image+"/-/etc/hello", map[string]string{"If-None-Match": tag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", resp.StatusCode)
+	}
+
+	//the ETag is derived from the layer holding the file
+	resp, _ = get(t, image+"/-/etc/base", nil)
+	if other := resp.Header.Get("ETag"); other == tag {
+		t.Errorf("expected files of different layers to have different ETags, got %s", other)
+	}
+}
+
+func TestServeListing(t *testing.T) {
+	_, _, _, image := newTestServer(t)
+
+	resp, body := get(t, image+"/ls/etc", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", resp.StatusCode, body)
+	}
+
+	var result listing
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range result.Entries {
+		paths = append(paths, e.Path)
+	}
+	if strings.Join(paths, ",") != "/etc/base,/etc/hello" {
+		t.Errorf("unexpected entries %v", paths)
+	}
+
+	resp, _ = get(t, image+"/ls/etc", map[string]string{"If-None-Match": resp.Header.Get("ETag")})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	_, srv, reg, image := newTestServer(t)
+
+	tests := []struct {
+		url    string
+		status int
+		code   string
+	}{
+		{url: image + "/-/etc/missing", status: http.StatusNotFound, code: codeFileNotFound},
+		{url: image + "/-/etc", status: http.StatusBadRequest, code: codeInvalidArgument},
+		{url: image + "/-/etc/hello?platform=linux", status: http.StatusBadRequest, code: codeInvalidArgument},
+		{url: srv.URL + "/v1/some-image", status: http.StatusNotFound, code: codeInvalidArgument},
+		{url: srv.URL + "/other", status: http.StatusNotFound, code: codeInvalidArgument},
+		{url: srv.URL + "/v1/" + reg.host + "/test/missing:latest/-/etc/hello", status: http.StatusNotFound, code: codeImageNotFound},
+		//only images of registries are served
+		{url: srv.URL + "/v1/oci:/tmp/layout:latest/-/etc/passwd", status: http.StatusBadRequest, code: codeInvalidArgument},
+		{url: srv.URL + "/v1/docker-archive:/tmp/image.tar/-/etc/passwd", status: http.StatusBadRequest, code: codeInvalidArgument},
+		{url: srv.URL + "/v1/docker-daemon:alpine/-/etc/passwd", status: http.StatusBadRequest, code: codeInvalidArgument},
+		{url: srv.URL + "/v1/containerd:alpine/-/etc/passwd", status: http.StatusBadRequest, code: codeInvalidArgument},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, tt.url, nil)
+		var report errorReport
+		json.Unmarshal([]byte(body), &report)
+		if resp.StatusCode != tt.status || report.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.url, tt.status, tt.code, resp.StatusCode, body)
+		}
+	}
+
+	resp, err := http.Post(image+"/-/etc/hello", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeReusesImages(t *testing.T) {
+	s, _, _, image := newTestServer(t)
+
+	get(t, image+"/-/etc/hello", nil)
+	if len(s.images) != 1 {
+		t.Fatalf("expected 1 open image, got %d", len(s.images))
+	}
+	var first *servedImage
+	for _, img := range s.images {
+		first = img
+	}
+
+	get(t, image+"/-/etc/base", nil)
+	if s.images[first.key] != first {
+		t.Error("expected the image to be reused within the resolve interval")
+	}
+
+	//an image resolved to the same digest again is kept
+	s.mu.Lock()
+	first.resolved = time.Now().Add(-2 * resolveInterval)
+	s.mu.Unlock()
+	get(t, image+"/-/etc/hello", nil)
+	if s.images[first.key] != first || time.Since(first.resolved) > resolveInterval {
+		t.Error("expected the image to be kept after resolving it again")
+	}
+
+	get(t, image+"/-/etc/hello?platform="+registry.DefaultPlatform().String(), nil)
+	if len(s.images) != 1 {
+		t.Errorf("expected the default platform to share the image, got %d images", len(s.images))
+	}
+	if first.users != 0 {
+		t.Errorf("expected all requests to release the image, got %d users", first.users)
+	}
+}
+
+func TestServeRepositories(t *testing.T) {
+	s, srv, reg, image := newTestServer(t)
+	s.repositories = map[string]bool{reg.host + "/test/app": true}
+
+	if resp, body := get(t, image+"/-/etc/hello", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for a served repository, got %d %s", resp.StatusCode, body)
+	}
+	if resp, body := get(t, srv.URL+"/v1/"+reg.host+"/test/other:latest/-/etc/hello", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for other repositories, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServeOpensImagesOnce(t *testing.T) {
+	_, _, reg, image := newTestServer(t)
+	reg.delay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(image + "/-/etc/hello")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected 200, got %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := reg.manifestRequests(); n != 1 {
+		t.Errorf("expected the manifest to be requested once, got %d requests", n)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/cedrickring/diana/pkg/cache"
+	"github.com/cedrickring/diana/pkg/diana"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// syncStateFile is written next to the sync file to remember the digests which were extracted
+const syncStateFile = ".diana-sync.json"
+
+var syncFile string
+
+// syncFileContent is the content of the sync file, usually diana.yaml
+type syncFileContent struct {
+	Images []syncEntry `yaml:"images"`
+}
+
+// syncEntry are the files to extract from an image into a destination directory
+type syncEntry struct {
+	Image    string     `yaml:"image" json:"image"`
+	Platform string     `yaml:"platform" json:"platform,omitempty"`
+	Dest     string     `yaml:"dest" json:"dest,omitempty"`
+	Files    []syncPath `yaml:"files" json:"files"`
+}
+
+// syncPath is a file or directory of the image, extracted with its base name unless renamed
+type syncPath struct {
+	Path string `yaml:"path" json:"path"`
+	As   string `yaml:"as" json:"as,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain path as well as a mapping with path and as
+func (p *syncPath) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&p.Path); err == nil {
+		return nil
+	}
+
+	type plain syncPath
+	return unmarshal((*plain)(p))
+}
+
+// syncState is the content of the state file
+type syncState struct {
+	Entries []syncRecord `json:"entries"`
+}
+
+// syncRecord is an entry of the sync file as it was extracted last
+type syncRecord struct {
+	syncEntry
+	Digest string `json:"digest"`
+}
+
+// syncResult is the outcome of an entry in --json output
+type syncResult struct {
+	Image    string       `json:"image"`
+	Platform string       `json:"platform"`
+	Digest   string       `json:"digest,omitempty"`
+	Status   string       `json:"status"`
+	Files    []diana.File `json:"files"`
+}
+
+const (
+	syncExtracted = "extracted"
+	syncUpToDate  = "up-to-date"
+	syncFailed    = "failed"
+)
+
+func newSyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Extract the files of many images listed in a sync file",
+		Long: `Extract the files of many images listed in a sync file:
+
+  images:
+    - image: alpine:3.18
+      platform: linux/arm64
+      dest: out/alpine
+      files:
+        - /etc/os-release
+        - path: /bin/busybox
+          as: bin/sh
+
+Destinations are relative to the directory of the sync file. Images are opened concurrently
+(--concurrency) and layers shared by several images are only pulled once. Entries are only
+extracted again if the digest of their image changed since the last sync, which is recorded
+in ` + syncStateFile + ` next to the sync file.
+
+If a lockfile written by diana lock exists next to the sync file, images are pulled by their
+locked digests and the checksums of the extracted files are verified.`,
+		Args: cobra.NoArgs,
+		Run: run(func(*cobra.Command, []string) error {
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			return runSync(ctx)
+		}),
+	}
+	cmd.Flags().StringVarP(&syncFile, "file", "f", "diana.yaml", "Sync file listing the images and files to extract")
+	return cmd
+}
+
+func runSync(ctx context.Context) error {
+	entries, err := readSyncFile(syncFile)
+	if err != nil {
+		return failf("Invalid sync file %s: %v", syncFile, err)
+	}
+	baseDir := filepath.Dir(syncFile)
+	statePath := filepath.Join(baseDir, syncStateFile)
+	state, err := readSyncState(statePath)
+	if err != nil {
+		logrus.WithError(err).Warnf("Ignoring the state of the last sync")
+		state = &syncState{}
+	}
+	lock, err := readLockFile(lockFilePath(syncFile))
+	if err != nil {
+		return failf("Invalid lockfile %s: %v", lockFilePath(syncFile), err)
+	}
+	if lock != nil {
+		logrus.Infof("Using the digests locked in %s", lockFilePath(syncFile))
+	}
+
+	opts, cleanup, err := syncOptions()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	s := &syncer{
+		opts:    opts,
+		baseDir: baseDir,
+		state:   state,
+		lock:    lock,
+		results: make([]syncResult, len(entries)),
+		records: make([]*syncRecord, len(entries)),
+	}
+	s.run(ctx, entries, s.syncImage)
+	finishProgress(nil)
+
+	next := &syncState{Entries: []syncRecord{}}
+	for _, record := range s.records {
+		if record != nil {
+			next.Entries = append(next.Entries, *record)
+		}
+	}
+	setResult(s.results)
+	if err := writeSyncState(statePath, next); err != nil {
+		return fail(err, "Failed to write %s", statePath)
+	}
+
+	failed := 0
+	for _, result := range s.results {
+		if result.Status == syncFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return s.failure("Failed to sync %d of %d entries", failed, len(entries))
+	}
+	return nil
+}
+
+// syncOptions returns the options to open the images of the sync file with. Without --cache,
+// layers shared by the images are pulled once into a temporary cache, which cleanup removes.
+func syncOptions() (diana.Options, func(), error) {
+	opts, err := dianaOptions()
+	if err != nil {
+		return opts, nil, err
+	}
+	if opts.Cache != nil {
+		return opts, func() {}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "diana-sync")
+	if err != nil {
+		return opts, nil, fail(err, "Failed to create a temporary layer cache")
+	}
+	opts.Cache = cache.New(dir, 0)
+	return opts, func() {
+		os.RemoveAll(dir)
+	}, nil
+}
+
+func readSyncFile(file string) ([]syncEntry, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	content := &syncFileContent{}
+	if err := yaml.UnmarshalStrict(b, content); err != nil {
+		return nil, err
+	}
+
+	for i, entry := range content.Images {
+		if entry.Image == "" {
+			return nil, errors.Errorf("entry %d has no image", i)
+		}
+		if len(entry.Files) == 0 {
+			return nil, errors.Errorf("entry %d (%s) has no files", i, entry.Image)
+		}
+		if entry.Platform != "" {
+			if _, err := registry.ParsePlatform(entry.Platform); err != nil {
+				return nil, errors.Wrapf(err, "entry %d (%s)", i, entry.Image)
+			}
+		}
+		for _, f := range entry.Files {
+			if f.Path == "" {
+				return nil, errors.Errorf("entry %d (%s) has a file without path", i, entry.Image)
+			}
+			if filepath.IsAbs(f.As) {
+				return nil, errors.Errorf("entry %d (%s): %s must be relative to dest", i, entry.Image, f.As)
+			}
+		}
+	}
+	return content.Images, nil
+}
+
+func readSyncState(file string) (*syncState, error) {
+	state := &syncState{}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return state, json.Unmarshal(b, state)
+}
+
+func writeSyncState(file string, state *syncState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(b, '\n'), 0644)
+}
+
+type syncer struct {
+	opts    diana.Options
+	baseDir string
+	state   *syncState
+	//lock is nil if there's no lockfile
+	lock *lockFile
+
+	//results and records are indexed like the entries of the sync file
+	mu      sync.Mutex
+	results []syncResult
+	records []*syncRecord
+	//err is the first error an image failed with
+	err error
+}
+
+// run calls fn concurrently for the entries of each image and platform, so every image is
+// opened once and shared by its entries
+func (s *syncer) run(ctx context.Context, entries []syncEntry, fn func(ctx context.Context, entries []syncEntry, group []int)) {
+	var groups [][]int
+	groupOf := map[string]int{}
+	for i, entry := range entries {
+		key := entry.Image + "@" + s.platform(entry).String()
+		g, ok := groupOf[key]
+		if !ok {
+			g = len(groups)
+			groupOf[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				fn(ctx, entries, group)
+			}
+		}()
+	}
+	for _, group := range groups {
+		jobs <- group
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// syncImage opens the image of the entries and extracts the ones which aren't up to date
+func (s *syncer) syncImage(ctx context.Context, entries []syncEntry, group []int) {
+	first := entries[group[0]]
+	opts := s.opts
+	opts.Platform = s.platform(first)
+	platform := opts.Platform.String()
+	failImage := func() {
+		for _, i := range group {
+			s.finish(i, syncResult{Image: first.Image, Platform: platform, Status: syncFailed, Files: []diana.File{}}, nil)
+		}
+	}
+
+	reference := first.Image
+	var locked *lockedImage
+	if s.lock != nil {
+		locked = s.lock.find(first.Image, platform)
+		if locked == nil {
+			s.logError(nil, "%s (%s) isn't locked, run diana lock", first.Image, platform)
+			failImage()
+			return
+		}
+		reference = pinReference(first.Image, locked.Digest, opts.DefaultTransport)
+	}
+
+	f, err := diana.OpenFS(ctx, reference, opts)
+	if err != nil {
+		s.logError(err, "Failed to open image %s", reference)
+		failImage()
+		return
+	}
+	defer f.Close()
+
+	digest := imageDigest(f.Info())
+	if locked != nil && digest != locked.Digest {
+		err := &registry.DigestMismatchError{Expected: locked.Digest, Actual: digest}
+		s.logError(err, "Image %s doesn't match the lockfile", reference)
+		failImage()
+		return
+	}
+
+	for _, i := range group {
+		entry := entries[i]
+		result := syncResult{Image: entry.Image, Platform: platform, Digest: digest, Files: []diana.File{}}
+		record := &syncRecord{syncEntry: entry, Digest: digest}
+		if s.upToDate(record) {
+			logrus.Infof("%s is up to date in %s", entry.Image, s.destDir(entry))
+			result.Status = syncUpToDate
+			s.finish(i, result, record)
+			continue
+		}
+
+		result.Status = syncExtracted
+		for _, p := range entry.Files {
+			target := s.destination(entry, p)
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				s.logError(err, "Failed to create the directory of %s", target)
+				result.Status = syncFailed
+				break
+			}
+
+			files, err := f.ExtractTo(ctx, p.Path, target)
+			result.Files = append(result.Files, files...)
+			if err == nil && locked != nil {
+				if err = locked.verify(files); err != nil {
+					//files which don't match the lockfile must not be used
+					os.RemoveAll(target)
+				}
+			}
+			if err != nil {
+				s.logError(err, "Failed to extract files from %s", entry.Image)
+				result.Status = syncFailed
+				break
+			}
+			logrus.Infof("Extracted %s of %s to %s", p.Path, entry.Image, target)
+		}
+
+		if result.Status == syncFailed {
+			//extracted again on the next sync
+			record = nil
+		}
+		s.finish(i, result, record)
+	}
+}
+
+// logError logs why an image failed, the first error becomes the cause of the failed command
+func (s *syncer) logError(err error, format string, args ...interface{}) {
+	if err == nil {
+		logrus.Errorf(format, args...)
+	} else {
+		logrus.WithError(err).Errorf(format, args...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// failure returns the error of the command once images failed, caused by the first error logged
+func (s *syncer) failure(format string, args ...interface{}) error {
+	if s.err == nil {
+		return failf(format, args...)
+	}
+	return fail(s.err, format, args...)
+}
+
+// imageDigest returns the digest of the manifest, images without one are identified by the
+// digest of their config
+func imageDigest(info diana.ImageInfo) string {
+	if info.Digest == "" {
+		return info.ID
+	}
+	return info.Digest
+}
+
+// platform returns the platform of the entry, the one of the command line if it has none
+func (s *syncer) platform(entry syncEntry) registry.Platform {
+	if entry.Platform == "" {
+		return s.opts.SourceOptions().Platform
+	}
+	//validated when reading the sync file
+	platform, _ := registry.ParsePlatform(entry.Platform)
+	return platform
+}
+
+func (s *syncer) finish(i int, result syncResult, record *syncRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results[i] = result
+	s.records[i] = record
+}
+
+func (s *syncer) destDir(entry syncEntry) string {
+	return filepath.Join(s.baseDir, filepath.FromSlash(entry.Dest))
+}
+
+func (s *syncer) destination(entry syncEntry, p syncPath) string {
+	if p.As != "" {
+		return filepath.Join(s.destDir(entry), filepath.FromSlash(p.As))
+	}
+
+	base := path.Base(path.Clean("/" + p.Path))
+	if base == "/" {
+		base = "rootfs"
+	}
+	return filepath.Join(s.destDir(entry), base)
+}
+
+// upToDate reports whether the entry has been extracted from the same digest by the last sync
+// and its files still exist
+func (s *syncer) upToDate(record *syncRecord) bool {
+	for _, last := range s.state.Entries {
+		if !reflect.DeepEqual(last.syncEntry, record.syncEntry) {
+			continue
+		}
+		if last.Digest != record.Digest {
+			return false
+		}
+		for _, p := range record.Files {
+			if _, err := os.Lstat(s.destination(record.syncEntry, p)); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+var versionFormat string
+
+// buildInfo is the JSON representation of the build info of a Go binary
+type buildInfo struct {
+	Path      string            `json:"path"`
+	GoVersion string            `json:"goVersion"`
+	Main      module            `json:"main"`
+	Deps      []module          `json:"deps,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+	VCS       *vcsInfo          `json:"vcs,omitempty"`
+}
+
+type module struct {
+	Path    string  `json:"path"`
+	Version string  `json:"version,omitempty"`
+	Sum     string  `json:"sum,omitempty"`
+	Replace *module `json:"replace,omitempty"`
+}
+
+type vcsInfo struct {
+	System   string `json:"system"`
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified"`
+}
+
+func newVersionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version <image> <path>",
+		Short: "Print the Go version, module, dependencies and VCS revision a Go binary of an image was built with",
+		Args:  cobra.ExactArgs(2),
+		Run: run(func(_ *cobra.Command, args []string) error {
+			if versionFormat != "text" && versionFormat != "json" {
+				return failf("Unknown format %s, expected text or json", versionFormat)
+			}
+
+			ctx, cancel := commandContext()
+			defer cancel()
+
+			opts, err := dianaOptions()
+			if err != nil {
+				return err
+			}
+			fs, closeImage, err := loadImage(ctx, args[0], opts)
+			if err != nil {
+				return err
+			}
+			defer closeImage()
+
+			content, err := fs.ReadFile(ctx, args[1])
+			if err != nil {
+				return fail(err, "Failed to read %s", args[1])
+			}
+
+			info, err := buildinfo.Read(bytes.NewReader(content))
+			if err != nil {
+				return fail(err, "Failed to read the build info of %s, is it a Go binary?", args[1])
+			}
+
+			if setResult(newBuildInfo(info)) {
+				return nil
+			}
+			if versionFormat == "text" {
+				fmt.Print(info.String())
+				return nil
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(newBuildInfo(info)); err != nil {
+				return fail(err, "Failed to write the build info")
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVarP(&versionFormat, "format", "", "text", "Output format: text (like go version -m) or json")
+	return cmd
+}
+
+func newBuildInfo(info *debug.BuildInfo) buildInfo {
+	b := buildInfo{
+		Path:      info.Path,
+		GoVersion: info.GoVersion,
+		Main:      *newModule(&info.Main),
+		Settings:  map[string]string{},
+	}
+	for _, dep := range info.Deps {
+		b.Deps = append(b.Deps, *newModule(dep))
+	}
+
+	for _, s := range info.Settings {
+		b.Settings[s.Key] = s.Value
+	}
+	if system, ok := b.Settings["vcs"]; ok {
+		b.VCS = &vcsInfo{
+			System:   system,
+			Revision: b.Settings["vcs.revision"],
+			Time:     b.Settings["vcs.time"],
+			Modified: b.Settings["vcs.modified"] == "true",
+		}
+	}
+
+	return b
+}
+
+func newModule(m *debug.Module) *module {
+	if m == nil {
+		return nil
+	}
+	return &module{
+		Path:    m.Path,
+		Version: m.Version,
+		Sum:     m.Sum,
+		Replace: newModule(m.Replace),
+	}
+}
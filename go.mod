@@ -1,14 +1,22 @@
 module github.com/cedrickring/diana
 
-go 1.12
+go 1.18
 
 require (
 	github.com/google/go-containerregistry v0.0.0-20190412005658-1d38b9cfdb9d
-	github.com/inconshreveable/mousetrap v1.0.0 // indirect
-	github.com/mitchellh/gox v1.0.1 // indirect
+	github.com/klauspost/compress v1.11.13
 	github.com/pkg/errors v0.8.1
 	github.com/sirupsen/logrus v1.4.1
 	github.com/spf13/cobra v0.0.3
-	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/spf13/pflag v1.0.3
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/mitchellh/gox v1.0.1 // indirect
+	golang.org/x/sys v0.0.0-20200923182605-d9f96fdee20d // indirect
 	golang.org/x/tools v0.0.0-20190501045030-23463209683d // indirect
 )
@@ -0,0 +1,225 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+const manifestFile = "manifest.json"
+
+// Archive is a tarball in the format produced by `docker save`
+type Archive struct {
+	file      *os.File
+	entries   map[string]entry
+	manifests []manifestEntry
+}
+
+// entry is the position of a file's content in the tarball
+type entry struct {
+	offset int64
+	size   int64
+}
+
+type manifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+type imageConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// Open indexes the tarball, the layers are read from it on demand
+func Open(file string) (*Archive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening archive")
+	}
+
+	a := &Archive{
+		file:    f,
+		entries: map[string]entry{},
+	}
+	if err := a.index(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *Archive) index() error {
+	tr := tar.NewReader(a.file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "reading archive")
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		//the reader is positioned at the start of the content
+		offset, err := a.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return errors.Wrap(err, "indexing archive")
+		}
+		a.entries[path.Clean(header.Name)] = entry{offset: offset, size: header.Size}
+	}
+
+	b, err := a.readFile(manifestFile)
+	if err != nil {
+		return errors.Wrap(err, "not a docker archive")
+	}
+	if err := json.Unmarshal(b, &a.manifests); err != nil {
+		return errors.Wrap(err, "decoding archive manifest")
+	}
+	if len(a.manifests) == 0 {
+		return errors.New("archive doesn't contain any images")
+	}
+
+	return nil
+}
+
+func (a *Archive) open(file string) (*io.SectionReader, error) {
+	e, ok := a.entries[path.Clean(file)]
+	if !ok {
+		return nil, errors.Errorf("%s not found in archive", file)
+	}
+	return io.NewSectionReader(a.file, e.offset, e.size), nil
+}
+
+func (a *Archive) readFile(file string) ([]byte, error) {
+	r, err := a.open(file)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
+
+// Image returns the image with the given tag. If tag is empty, the archive must contain a single image.
+func (a *Archive) Image(tag string) (*Image, error) {
+	m, err := a.findManifest(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := a.readFile(m.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading image config")
+	}
+
+	cfg := imageConfig{}
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, errors.Wrap(err, "decoding image config")
+	}
+	if len(cfg.RootFS.DiffIDs) != len(m.Layers) {
+		return nil, errors.Errorf("image config lists %d layers, archive contains %d", len(cfg.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	img := &Image{
+		archive: a,
+		config:  config,
+		layers:  map[string]string{},
+		manifest: &registry.Manifest{
+			SchemaVersion: 2,
+			MediaType:     registry.MediaTypeDockerManifest,
+			Config: registry.ManifestConfig{
+				MediaType: registry.MediaTypeDockerConfig,
+				Size:      int64(len(config)),
+				Digest:    registry.Digest(config),
+			},
+		},
+	}
+
+	for i, file := range m.Layers {
+		e, ok := a.entries[path.Clean(file)]
+		if !ok {
+			return nil, errors.Errorf("layer %s not found in archive", file)
+		}
+
+		//layers are identified by their uncompressed digest, the compression is detected when reading
+		digest := cfg.RootFS.DiffIDs[i]
+		img.layers[digest] = file
+		img.manifest.Layers = append(img.manifest.Layers, registry.Layer{
+			Size:   e.size,
+			Digest: digest,
+		})
+	}
+
+	return img, nil
+}
+
+func (a *Archive) findManifest(tag string) (*manifestEntry, error) {
+	if tag == "" {
+		if len(a.manifests) > 1 {
+			return nil, errors.New("archive contains multiple images, please specify a tag")
+		}
+		return &a.manifests[0], nil
+	}
+
+	want := normalizeTag(tag)
+	for i, m := range a.manifests {
+		for _, t := range m.RepoTags {
+			if normalizeTag(t) == want {
+				return &a.manifests[i], nil
+			}
+		}
+	}
+	return nil, errors.Errorf("image %s not found in archive", tag)
+}
+
+func normalizeTag(tag string) string {
+	if t, err := name.NewTag(tag, name.WeakValidation); err == nil {
+		return t.Name()
+	}
+	return tag
+}
+
+// Image is a single image of an archive
+type Image struct {
+	archive  *Archive
+	manifest *registry.Manifest
+	config   []byte
+	layers   map[string]string
+}
+
+func (i *Image) Manifest() *registry.Manifest {
+	return i.manifest
+}
+
+func (i *Image) RawConfig() []byte {
+	return i.config
+}
+
+// Layer opens the layer with the given digest
+func (i *Image) Layer(digest string) (io.ReadCloser, error) {
+	file, ok := i.layers[digest]
+	if !ok {
+		return nil, errors.Errorf("layer %s not found in archive", digest)
+	}
+
+	r, err := i.archive.open(file)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(r), nil
+}
@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+type testImage struct {
+	tags  []string
+	files map[string]string
+}
+
+func tarFiles(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// writeArchive writes the images like docker save and returns the path of the tarball
+func writeArchive(t *testing.T, manifest func(entries []manifestEntry) []manifestEntry, images ...testImage) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+	}
+
+	var entries []manifestEntry
+	for _, img := range images {
+		layer := tarFiles(t, img.files)
+		diffID := registry.Digest(layer)
+		layerName := diffID[len("sha256:"):] + "/layer.tar"
+		tw.WriteHeader(&tar.Header{Name: diffID[len("sha256:"):] + "/", Typeflag: tar.TypeDir, Mode: 0755})
+		add(layerName, layer)
+
+		config, _ := json.Marshal(map[string]interface{}{
+			"rootfs": map[string]interface{}{"type": "layers", "diff_ids": []string{diffID}},
+		})
+		configName := registry.Digest(config)[len("sha256:"):] + ".json"
+		add(configName, config)
+
+		entries = append(entries, manifestEntry{Config: configName, RepoTags: img.tags, Layers: []string{layerName}})
+	}
+	if manifest != nil {
+		entries = manifest(entries)
+	}
+
+	b, _ := json.Marshal(entries)
+	add(manifestFile, b)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "image.tar")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func readLayer(t *testing.T, img *Image, layer registry.Layer) map[string]string {
+	rc, err := img.Layer(layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	files := map[string]string{}
+	tr := tar.NewReader(rc)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		b, _ := ioutil.ReadAll(tr)
+		files[h.Name] = string(b)
+	}
+	return files
+}
+
+func TestArchiveImage(t *testing.T) {
+	file := writeArchive(t, nil,
+		testImage{tags: []string{"alpine:3.18"}, files: map[string]string{"etc/alpine-release": "3.18.0"}},
+		testImage{tags: []string{"docker.io/library/alpine:latest", "registry.example.com/alpine:latest"}, files: map[string]string{"etc/alpine-release": "3.19.0"}},
+	)
+	a, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	tests := []struct {
+		tag     string
+		release string
+	}{
+		{tag: "alpine:3.18", release: "3.18.0"},
+		{tag: "docker.io/library/alpine:3.18", release: "3.18.0"},
+		//tags are normalized like image references
+		{tag: "alpine", release: "3.19.0"},
+		{tag: "registry.example.com/alpine:latest", release: "3.19.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			img, err := a.Image(tt.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifest := img.Manifest()
+			if manifest.Config.Digest != registry.Digest(img.RawConfig()) {
+				t.Errorf("expected the digest of the config, got %s", manifest.Config.Digest)
+			}
+			if len(manifest.Layers) != 1 {
+				t.Fatalf("expected 1 layer, got %d", len(manifest.Layers))
+			}
+			if files := readLayer(t, img, manifest.Layers[0]); files["etc/alpine-release"] != tt.release {
+				t.Errorf("expected release %s, got %v", tt.release, files)
+			}
+		})
+	}
+
+	for _, tag := range []string{"", "alpine:3.17", "ubuntu"} {
+		if _, err := a.Image(tag); err == nil {
+			t.Errorf("expected %q not to select an image", tag)
+		}
+	}
+}
+
+func TestArchiveSingleImage(t *testing.T) {
+	//images saved by id don't have any tags
+	file := writeArchive(t, nil, testImage{files: map[string]string{"etc/hostname": "diana"}})
+	a, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	img, err := a.Image("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readLayer(t, img, img.Manifest().Layers[0]); files["etc/hostname"] != "diana" {
+		t.Errorf("expected etc/hostname, got %v", files)
+	}
+	if _, err := img.Layer(registry.Digest([]byte("other"))); err == nil {
+		t.Error("expected an unknown layer to fail")
+	}
+}
+
+func TestArchiveRejectsInvalidImages(t *testing.T) {
+	image := testImage{tags: []string{"app:latest"}, files: map[string]string{"etc/hostname": "diana"}}
+
+	tests := []struct {
+		name     string
+		manifest func(entries []manifestEntry) []manifestEntry
+	}{
+		{name: "missing layer", manifest: func(entries []manifestEntry) []manifestEntry {
+			entries[0].Layers = []string{"missing/layer.tar"}
+			return entries
+		}},
+		{name: "missing config", manifest: func(entries []manifestEntry) []manifestEntry {
+			entries[0].Config = "missing.json"
+			return entries
+		}},
+		{name: "more layers than diff ids", manifest: func(entries []manifestEntry) []manifestEntry {
+			entries[0].Layers = append(entries[0].Layers, entries[0].Layers[0])
+			return entries
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Open(writeArchive(t, tt.manifest, image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+
+			if _, err := a.Image("app:latest"); err == nil {
+				t.Error("expected the image to be rejected")
+			}
+		})
+	}
+
+	//tarballs without manifest aren't docker archives
+	file := filepath.Join(t.TempDir(), "layer.tar")
+	if err := ioutil.WriteFile(file, tarFiles(t, map[string]string{"etc/hostname": "diana"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := Open(file); err == nil {
+		a.Close()
+		t.Error("expected a plain tarball to be rejected")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.tar")); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("expected a missing archive to fail with not exist, got %v", err)
+	}
+}
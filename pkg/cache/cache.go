@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	algorithm = "sha256"
+
+	// DefaultMaxSize is used if no size cap is configured (5 GiB)
+	DefaultMaxSize int64 = 5 << 30
+)
+
+// Cache is a content addressable store for blobs, shared by all diana invocations.
+// Blobs are stored as <dir>/blobs/sha256/<hex> and evicted least recently used first.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu sync.Mutex
+	//pulling are the blobs being downloaded into the cache, the channel is closed once done
+	pulling map[string]chan struct{}
+}
+
+type Entry struct {
+	Digest   string
+	Size     int64
+	LastUsed time.Time
+	path     string
+}
+
+// DefaultDir returns $XDG_CACHE_HOME/diana or ~/.cache/diana
+func DefaultDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "diana")
+	}
+	return filepath.Join(util.HomeDir(), ".cache", "diana")
+}
+
+// New creates a cache in dir, a maxSize <= 0 disables eviction
+func New(dir string, maxSize int64) *Cache {
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		pulling: map[string]chan struct{}{},
+	}
+}
+
+// claim registers the download of the blob. It returns nil if the caller has to download the
+// blob, otherwise a channel which is closed once the running download is done.
+func (c *Cache) claim(digest string) chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wait, ok := c.pulling[digest]; ok {
+		return wait
+	}
+	c.pulling[digest] = make(chan struct{})
+	return nil
+}
+
+// release marks the claimed download as done, whether the blob was cached or not
+func (c *Cache) release(digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wait, ok := c.pulling[digest]; ok {
+		close(wait)
+		delete(c.pulling, digest)
+	}
+}
+
+func (c *Cache) blobDir() string {
+	return filepath.Join(c.dir, "blobs", algorithm)
+}
+
+func (c *Cache) path(digest string) (string, error) {
+	hexDigest := strings.TrimPrefix(digest, algorithm+":")
+	if hexDigest == digest || len(hexDigest) != sha256.Size*2 {
+		return "", errors.Errorf("unsupported digest %s", digest)
+	}
+	if _, err := hex.DecodeString(hexDigest); err != nil {
+		return "", errors.Errorf("invalid digest %s", digest)
+	}
+	return filepath.Join(c.blobDir(), hexDigest), nil
+}
+
+// Open returns the cached blob, its content is verified while it's read and corrupted blobs are
+// removed. ok is false if the blob isn't cached.
+func (c *Cache) Open(digest string) (rc io.ReadCloser, ok bool, err error) {
+	path, err := c.path(digest)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errors.Wrap(err, "opening cached blob")
+	}
+
+	blob, err := registry.NewVerifyingReader(f, digest)
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+
+	//the modification time is used to track the last usage
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		logrus.WithError(err).Debugf("Failed to update the last usage of blob %s", digest)
+	}
+
+	return &cachedBlob{ReadCloser: blob, digest: digest, path: path}, true, nil
+}
+
+// cachedBlob removes the blob from the cache if its content doesn't match the digest
+type cachedBlob struct {
+	io.ReadCloser
+	digest string
+	path   string
+}
+
+func (b *cachedBlob) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if _, ok := err.(*registry.DigestMismatchError); ok {
+		logrus.Warnf("Removing corrupted blob %s from cache", b.digest)
+		os.Remove(b.path)
+	}
+	return n, err
+}
+
+// Contains reports whether the blob is cached, its content isn't verified
+func (c *Cache) Contains(digest string) bool {
+	path, err := c.path(digest)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// Writer returns a writer which adds the blob to the cache once it's committed.
+// The content is only stored if it matches the digest.
+func (c *Cache) Writer(digest string) (*Writer, error) {
+	path, err := c.path(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(c.blobDir(), 0755); err != nil {
+		return nil, errors.Wrap(err, "creating cache directory")
+	}
+
+	f, err := ioutil.TempFile(c.blobDir(), ".tmp-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating temporary cache file")
+	}
+
+	return &Writer{
+		cache:  c,
+		file:   f,
+		hash:   sha256.New(),
+		digest: digest,
+		path:   path,
+	}, nil
+}
+
+// Entries lists all cached blobs, the most recently used first
+func (c *Cache) Entries() ([]Entry, error) {
+	files, err := ioutil.ReadDir(c.blobDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading cache directory")
+	}
+
+	var entries []Entry
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		entries = append(entries, Entry{
+			Digest:   algorithm + ":" + f.Name(),
+			Size:     f.Size(),
+			LastUsed: f.ModTime(),
+			path:     filepath.Join(c.blobDir(), f.Name()),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+
+	return entries, nil
+}
+
+// Prune evicts the least recently used blobs until the cache fits into maxSize
+// and removes leftovers of interrupted downloads.
+func (c *Cache) Prune(maxSize int64) ([]Entry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	c.removeStaleTempFiles()
+
+	var size int64
+	var removed []Entry
+	for _, e := range entries {
+		size += e.Size
+		if maxSize > 0 && size > maxSize {
+			if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+				return removed, errors.Wrapf(err, "removing blob %s", e.Digest)
+			}
+			removed = append(removed, e)
+		}
+	}
+
+	return removed, nil
+}
+
+// Clear removes all cached blobs
+func (c *Cache) Clear() error {
+	return errors.Wrap(os.RemoveAll(filepath.Join(c.dir, "blobs")), "clearing cache")
+}
+
+func (c *Cache) removeStaleTempFiles() {
+	files, _ := filepath.Glob(filepath.Join(c.blobDir(), ".tmp-*"))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && time.Since(info.ModTime()) > 24*time.Hour {
+			os.Remove(f)
+		}
+	}
+}
+
+type Writer struct {
+	cache  *Cache
+	file   *os.File
+	hash   hash.Hash
+	digest string
+	path   string
+	done   bool
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.done {
+		return len(p), nil
+	}
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// Commit moves the blob into the cache if the content matches the digest
+func (w *Writer) Commit() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return errors.Wrap(err, "closing cache file")
+	}
+
+	if actual := algorithm + ":" + hex.EncodeToString(w.hash.Sum(nil)); actual != w.digest {
+		os.Remove(w.file.Name())
+		return errors.Errorf("not caching blob %s, content has digest %s", w.digest, actual)
+	}
+
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		os.Remove(w.file.Name())
+		return errors.Wrap(err, "moving blob into cache")
+	}
+
+	if w.cache.maxSize > 0 {
+		if _, err := w.cache.Prune(w.cache.maxSize); err != nil {
+			logrus.WithError(err).Warnf("Failed to prune cache")
+		}
+	}
+
+	return nil
+}
+
+// Abort discards the partially written blob
+func (w *Writer) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func put(t *testing.T, c *Cache, content string) string {
+	digest := registry.Digest([]byte(content))
+	w, err := c.Writer(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func read(c *Cache, digest string) (string, bool, error) {
+	rc, ok, err := c.Open(digest)
+	if err != nil || !ok {
+		return "", ok, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	return string(b), true, err
+}
+
+func TestCacheOpen(t *testing.T) {
+	c := New(t.TempDir(), 0)
+	digest := put(t, c, "blob")
+
+	if content, ok, err := read(c, digest); err != nil || !ok || content != "blob" {
+		t.Errorf("expected a cache hit, got %q, %v, %v", content, ok, err)
+	}
+	if _, ok, err := read(c, registry.Digest([]byte("missing"))); err != nil || ok {
+		t.Errorf("expected a cache miss, got %v, %v", ok, err)
+	}
+	if _, _, err := c.Open("sha256:../../etc/passwd"); err == nil {
+		t.Error("expected an invalid digest to be rejected")
+	}
+}
+
+func TestCacheRejectsCorruptedBlobs(t *testing.T) {
+	c := New(t.TempDir(), 0)
+	digest := put(t, c, "blob")
+
+	path, _ := c.path(digest)
+	if err := ioutil.WriteFile(path, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := read(c, digest)
+	if _, ok := err.(*registry.DigestMismatchError); !ok {
+		t.Fatalf("expected a DigestMismatchError, got %v", err)
+	}
+	if c.Contains(digest) {
+		t.Error("expected the corrupted blob to be removed")
+	}
+}
+
+func TestWriterRejectsOtherContent(t *testing.T) {
+	c := New(t.TempDir(), 0)
+	digest := registry.Digest([]byte("blob"))
+
+	w, err := c.Writer(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("other"))
+	if err := w.Commit(); err == nil {
+		t.Error("expected other content not to be committed")
+	}
+	if c.Contains(digest) {
+		t.Error("expected the blob not to be cached")
+	}
+}
+
+func TestPruneEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(t.TempDir(), 0)
+	oldest := put(t, c, "oldest")
+	older := put(t, c, "older")
+	newest := put(t, c, "newest")
+
+	for i, digest := range []string{oldest, older, newest} {
+		path, _ := c.path(digest)
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//reading a blob makes it the most recently used one
+	if _, ok, err := read(c, oldest); !ok || err != nil {
+		t.Fatalf("expected a cache hit, got %v, %v", ok, err)
+	}
+
+	removed, err := c.Prune(int64(len("oldest") + len("newest")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Digest != older {
+		t.Errorf("expected only %s to be evicted, got %v", older, removed)
+	}
+	for _, digest := range []string{oldest, newest} {
+		if !c.Contains(digest) {
+			t.Errorf("expected %s to be kept", digest)
+		}
+	}
+}
+
+func TestWriterCommitPrunes(t *testing.T) {
+	c := New(t.TempDir(), int64(len("first")+len("second")))
+	first := put(t, c, "first")
+	path, _ := c.path(first)
+	used := time.Now().Add(-time.Hour)
+	os.Chtimes(path, used, used)
+
+	put(t, c, "second")
+	put(t, c, "third")
+	if c.Contains(first) {
+		t.Error("expected the least recently used blob to be evicted once the cache is full")
+	}
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 cached blobs, got %v", entries)
+	}
+}
+
+// countingClient serves every blob with the content and counts the downloads
+type countingClient struct {
+	registry.Client
+	content   string
+	downloads int
+}
+
+func (c *countingClient) GetBlob(context.Context, name.Reference, string) (io.ReadCloser, error) {
+	c.downloads++
+	return ioutil.NopCloser(strings.NewReader(c.content)), nil
+}
+
+func TestClientCachesBlobs(t *testing.T) {
+	dir := t.TempDir()
+	remote := &countingClient{content: "blob"}
+	client := New(dir, 0).Client(remote)
+	ref, err := name.ParseReference("registry.example.com/app:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := registry.Digest([]byte("blob"))
+
+	for i := 0; i < 2; i++ {
+		rc, err := client.GetBlob(context.Background(), ref, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(b) != "blob" {
+			t.Fatalf("expected blob, got %q, %v", b, err)
+		}
+	}
+	if remote.downloads != 1 {
+		t.Errorf("expected the blob to be downloaded once, got %d downloads", remote.downloads)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))); err != nil {
+		t.Errorf("expected the blob to be cached: %v", err)
+	}
+}
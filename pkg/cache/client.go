@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"io"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sirupsen/logrus"
+)
+
+type cachingClient struct {
+	registry.Client
+	cache *Cache
+}
+
+// Client wraps the registry client so blobs are served from the cache and
+// downloaded blobs are added to it
+func (c *Cache) Client(client registry.Client) registry.Client {
+	return &cachingClient{
+		Client: client,
+		cache:  c,
+	}
+}
+
+func (c *cachingClient) GetBlob(ctx context.Context, ref name.Reference, digest string) (io.ReadCloser, error) {
+	return c.open(ctx, digest, func() (io.ReadCloser, error) {
+		return c.Client.GetBlob(ctx, ref, digest)
+	})
+}
+
+func (c *cachingClient) GetLayer(ctx context.Context, ref name.Reference, layer registry.Layer) (io.ReadCloser, error) {
+	return c.open(ctx, layer.Digest, func() (io.ReadCloser, error) {
+		return c.Client.GetLayer(ctx, ref, layer)
+	})
+}
+
+// open reads the blob from the cache or downloads it into the cache. Blobs which are already
+// being downloaded, e.g. layers shared by images pulled concurrently, are downloaded only once.
+func (c *cachingClient) open(ctx context.Context, digest string, fetch func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	for {
+		cached, ok, err := c.cache.Open(digest)
+		if err != nil {
+			logrus.WithError(err).Debugf("Not using cache for blob %s", digest)
+			return fetch()
+		}
+		if ok {
+			logrus.Debugf("Using cached blob %s", digest)
+			return cached, nil
+		}
+
+		wait := c.cache.claim(digest)
+		if wait == nil {
+			break
+		}
+		logrus.Debugf("Waiting for blob %s, it's being downloaded already", digest)
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	blob, err := fetch()
+	if err != nil {
+		c.cache.release(digest)
+		return nil, err
+	}
+
+	w, err := c.cache.Writer(digest)
+	if err != nil {
+		c.cache.release(digest)
+		logrus.WithError(err).Warnf("Can't add blob %s to cache", digest)
+		return blob, nil
+	}
+
+	return &teeReadCloser{ReadCloser: blob, writer: w}, nil
+}
+
+// teeReadCloser commits the blob to the cache once it has been read completely
+type teeReadCloser struct {
+	io.ReadCloser
+	writer *Writer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		if _, werr := t.writer.Write(p[:n]); werr != nil {
+			logrus.WithError(werr).Warnf("Failed to write blob %s to cache", t.writer.digest)
+			t.writer.Abort()
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		if cerr := t.writer.Commit(); cerr != nil {
+			logrus.WithError(cerr).Warnf("Failed to add blob %s to cache", t.writer.digest)
+		}
+		t.writer.cache.release(t.writer.digest)
+	case err != nil:
+		t.writer.Abort()
+		t.writer.cache.release(t.writer.digest)
+	}
+
+	return n, err
+}
+
+func (t *teeReadCloser) Close() error {
+	t.writer.Abort()
+	t.writer.cache.release(t.writer.digest)
+	return t.ReadCloser.Close()
+}
@@ -0,0 +1,164 @@
+package containerd
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/oci"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultRoot = "/var/lib/containerd"
+
+	contentDir   = "io.containerd.content.v1.content/blobs"
+	metadataDB   = "io.containerd.metadata.v1.bolt/meta.db"
+	digestAlgSep = ":"
+)
+
+var (
+	bucketVersion = []byte("v1")
+	bucketImages  = []byte("images")
+	bucketTarget  = []byte("target")
+	keyDigest     = []byte("digest")
+	keyMediaType  = []byte("mediatype")
+	keySize       = []byte("size")
+)
+
+// Store reads images from the content store and metadata database of containerd
+type Store struct {
+	root      string
+	namespace string
+	blobs     *oci.BlobStore
+}
+
+// NewStore opens the containerd state in root, images are looked up in all namespaces
+// if namespace is empty
+func NewStore(root, namespace string) *Store {
+	return &Store{
+		root:      root,
+		namespace: namespace,
+		blobs:     oci.NewBlobStore(filepath.Join(root, contentDir)),
+	}
+}
+
+// Image returns the image with the given name or manifest digest
+func (s *Store) Image(image string, platform registry.Platform) (*oci.Image, error) {
+	digest := image
+	if !strings.HasPrefix(image, "sha256"+digestAlgSep) {
+		target, err := s.Resolve(image)
+		if err != nil {
+			return nil, err
+		}
+		digest = target.Digest
+	}
+
+	return s.blobs.Image(digest, platform)
+}
+
+// Resolve looks up the target manifest of the image in the metadata database
+func (s *Store) Resolve(image string) (*registry.Descriptor, error) {
+	want := normalizeName(image)
+
+	var target *registry.Descriptor
+	err := s.view(func(tx *bolt.Tx) error {
+		v1 := tx.Bucket(bucketVersion)
+		if v1 == nil {
+			return errors.New("unsupported containerd metadata schema")
+		}
+
+		return v1.ForEach(func(namespace, _ []byte) error {
+			if target != nil || (s.namespace != "" && s.namespace != string(namespace)) {
+				return nil
+			}
+
+			//v1 also holds plain keys like the schema version, which aren't namespaces
+			ns := v1.Bucket(namespace)
+			if ns == nil {
+				return nil
+			}
+			images := ns.Bucket(bucketImages)
+			if images == nil {
+				return nil
+			}
+
+			return images.ForEach(func(imageName, _ []byte) error {
+				if target != nil || normalizeName(string(imageName)) != want {
+					return nil
+				}
+
+				img := images.Bucket(imageName)
+				if img == nil {
+					return nil
+				}
+				t := img.Bucket(bucketTarget)
+				if t == nil {
+					return nil
+				}
+
+				size, _ := binary.Varint(t.Get(keySize))
+				target = &registry.Descriptor{
+					MediaType: string(t.Get(keyMediaType)),
+					Digest:    string(t.Get(keyDigest)),
+					Size:      size,
+				}
+				logrus.Debugf("Found image %s in namespace %s", imageName, namespace)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, errors.Errorf("image %s not found in containerd", image)
+	}
+
+	return target, nil
+}
+
+// view opens a copy of the metadata database, as containerd holds an exclusive lock on it
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	tmp, err := ioutil.TempFile("", "diana-meta-*.db")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	src, err := os.Open(filepath.Join(s.root, metadataDB))
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "opening containerd metadata")
+	}
+	_, err = io.Copy(tmp, src)
+	src.Close()
+	tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "copying containerd metadata")
+	}
+
+	db, err := bolt.Open(tmp.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return errors.Wrap(err, "opening containerd metadata")
+	}
+	defer db.Close()
+
+	return db.View(fn)
+}
+
+// normalizeName makes docker.io/library/alpine:latest and alpine comparable
+func normalizeName(image string) string {
+	if ref, err := name.ParseReference(image, name.WeakValidation); err == nil {
+		return ref.Name()
+	}
+	return image
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dockerSocket       = "/var/run/docker.sock"
+	podmanRootSocket   = "/run/podman/podman.sock"
+	podmanRootlessPath = "podman/podman.sock"
+
+	// the host is ignored when dialing the unix socket
+	apiURL = "http://docker"
+)
+
+// Client talks to the Docker Engine API of a local Docker or Podman daemon
+type Client struct {
+	socket string
+	client *http.Client
+}
+
+func NewClient(socket string) *Client {
+	dialer := &net.Dialer{}
+	return &Client{
+		socket: socket,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// DetectSocket returns the socket of $DOCKER_HOST, the Docker socket or the
+// (rootless) Podman socket, whichever exists first
+func DetectSocket() (string, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if !strings.HasPrefix(host, "unix://") {
+			return "", errors.Errorf("only unix sockets are supported, DOCKER_HOST is %s", host)
+		}
+		return strings.TrimPrefix(host, "unix://"), nil
+	}
+
+	candidates := []string{dockerSocket, podmanRootSocket}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates, filepath.Join(runtimeDir, podmanRootlessPath))
+	}
+
+	for _, socket := range candidates {
+		if _, err := os.Stat(socket); err == nil {
+			return socket, nil
+		}
+	}
+	return "", errors.Errorf("no docker or podman socket found (tried %s)", strings.Join(candidates, ", "))
+}
+
+// SaveImage exports the image like `docker save`, the caller has to close the tarball
+func (c *Client) SaveImage(ctx context.Context, image string) (io.ReadCloser, error) {
+	//the repository may contain slashes which are part of the path
+	segments := strings.Split(image, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+
+	request, err := http.NewRequest(http.MethodGet, apiURL+"/images/"+strings.Join(segments, "/")+"/get", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating image export request")
+	}
+
+	logrus.Infof("Exporting image %s from daemon at %s", image, c.socket)
+
+	response, err := c.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to daemon at %s", c.socket)
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Body, nil
+	case http.StatusNotFound:
+		response.Body.Close()
+		return nil, errors.Wrapf(registry.ErrNotFound, "%s in daemon", image)
+	default:
+		defer response.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, errors.Errorf("exporting image failed with status %d: %s", response.StatusCode, strings.TrimSpace(string(msg)))
+	}
+}
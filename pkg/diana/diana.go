@@ -0,0 +1,251 @@
+// Package diana extracts files from container images without a container runtime. It's what
+// the diana command is built on and the API for embedding diana into other tools.
+package diana
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/cache"
+	"github.com/cedrickring/diana/pkg/docker"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/source"
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+)
+
+// InvalidLayerError is returned if FromLayer doesn't reference a layer of the image
+type InvalidLayerError struct {
+	Layer string
+	Err   error
+}
+
+func (e *InvalidLayerError) Error() string {
+	return e.Err.Error()
+}
+
+// Options configure how images are opened. The zero value pulls images from their registries
+// with the credentials of the docker config.
+type Options struct {
+	// Platform to pick from multi-platform images, defaults to linux on the current architecture
+	Platform registry.Platform
+	// DefaultTransport is used for references without transport prefix, defaults to docker
+	DefaultTransport string
+
+	// Credentials returns the credentials for a registry, defaults to the docker config. Use
+	// Anonymous to pull without credentials.
+	Credentials func(registry string) (username, password string, err error)
+	// Transport is used for all requests to registries, see registry.NewTransport for TLS options
+	Transport http.RoundTripper
+	// Mirrors are tried in order before the registry itself, keyed by registry host
+	Mirrors map[string][]string
+	// Cache keeps layers pulled from registries across runs if not nil
+	Cache *cache.Cache
+	// Progress is notified about layers downloaded from registries if not nil. If it's a
+	// Planner as well, it's told which layers are going to be pulled first.
+	Progress registry.Progress
+	// Concurrency is the number of layers downloaded at once, one after another if below 2
+	Concurrency int
+
+	// IncludeBaseLayer also pulls the first layer, which is skipped as it's probably the base image
+	IncludeBaseLayer bool
+	// FromLayer uses the filesystem as it was after the layer with this index or digest (prefix)
+	FromLayer string
+	// IncludeDeleted falls back to the last version of paths deleted by later layers
+	IncludeDeleted bool
+
+	// OutputDir is the directory Extract writes the files to, defaults to the working directory
+	OutputDir string
+	// WithDeps makes Extract write the interpreter and shared libraries of ELF binaries as well,
+	// every file keeps its path of the image like in a sysroot. It implies IncludeBaseLayer, as
+	// the libraries usually come with the base image.
+	WithDeps bool
+
+	ContainerdRoot      string
+	ContainerdNamespace string
+}
+
+// Planner is notified about the layers which are pulled and the ones which are skipped before
+// the downloads start, e.g. to show the total progress
+type Planner interface {
+	Plan(pull, skip []registry.Layer)
+}
+
+// Anonymous are the credentials of registries which are pulled from without authentication
+func Anonymous(string) (string, string, error) {
+	return "", "", nil
+}
+
+// SourceOptions returns the options to open images with the source package
+func (o Options) SourceOptions() source.Options {
+	opts := source.Options{
+		Platform:            o.Platform,
+		DefaultTransport:    o.DefaultTransport,
+		RegistryTransport:   o.Transport,
+		Credentials:         o.Credentials,
+		Cache:               o.Cache,
+		Progress:            o.Progress,
+		Mirrors:             o.Mirrors,
+		ContainerdRoot:      o.ContainerdRoot,
+		ContainerdNamespace: o.ContainerdNamespace,
+	}
+	if opts.Platform == (registry.Platform{}) {
+		opts.Platform = registry.DefaultPlatform()
+	}
+	if opts.Credentials == nil {
+		opts.Credentials = docker.GetCredentials
+	}
+	return opts
+}
+
+// SelectLayers returns the layers to pull honoring FromLayer and IncludeBaseLayer
+func (o Options) SelectLayers(layers []registry.Layer) ([]registry.Layer, error) {
+	if o.FromLayer != "" {
+		n, err := rootfs.FindLayer(layers, o.FromLayer)
+		if err != nil {
+			return nil, &InvalidLayerError{Layer: o.FromLayer, Err: err}
+		}
+		layers = layers[:n+1]
+	}
+	return rootfs.SelectLayers(layers, o.IncludeBaseLayer || o.WithDeps), nil
+}
+
+// ImageInfo describes an opened image
+type ImageInfo struct {
+	Reference string `json:"reference"`
+	Transport string `json:"transport"`
+	Digest    string `json:"digest,omitempty"`
+	// ID is the digest of the image config
+	ID        string `json:"id,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	Platform  string `json:"platform"`
+}
+
+// LayerInfo describes a pulled layer
+type LayerInfo struct {
+	Digest     string `json:"digest"`
+	MediaType  string `json:"mediaType,omitempty"`
+	Size       int64  `json:"size"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Describe returns the info of an image opened with the source package
+func Describe(img source.Image, platform registry.Platform) ImageInfo {
+	manifest := img.Manifest()
+	return ImageInfo{
+		Reference: img.Reference().String(),
+		Transport: img.Reference().Transport,
+		Digest:    manifest.Digest,
+		ID:        manifest.Config.Digest,
+		MediaType: manifest.MediaType,
+		Platform:  platform.String(),
+	}
+}
+
+// Image is an image with its selected layers merged into one filesystem
+type Image struct {
+	fs   *rootfs.FS
+	opts Options
+	info ImageInfo
+}
+
+// Open opens the image referenced with an optional transport prefix (e.g. docker-archive:) and
+// merges its layers. The image has to be closed to release temporary files.
+func Open(ctx context.Context, reference string, opts Options) (*Image, error) {
+	sourceOpts := opts.SourceOptions()
+	img, err := source.Open(ctx, reference, sourceOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := img.Manifest().Layers
+	selected, err := opts.SelectLayers(layers)
+	if err != nil {
+		img.Close()
+		return nil, err
+	}
+	if planner, ok := opts.Progress.(Planner); ok {
+		planner.Plan(selected, skippedLayers(layers, selected))
+	}
+
+	stopPrefetch := rootfs.Prefetch(ctx, img, selected, opts.Concurrency)
+	fs, err := rootfs.Load(ctx, img, selected)
+	//all layers are loaded, or the downloads still running are useless
+	stopPrefetch()
+	if err != nil {
+		img.Close()
+		return nil, errors.Wrapf(err, "pulling image %s", reference)
+	}
+
+	return &Image{
+		fs:   fs,
+		opts: opts,
+		info: Describe(img, sourceOpts.Platform),
+	}, nil
+}
+
+// skippedLayers returns the layers which aren't selected
+func skippedLayers(layers, selected []registry.Layer) []registry.Layer {
+	skipped := layers[:0:0]
+	for _, layer := range layers {
+		found := false
+		for _, s := range selected {
+			if s.Digest == layer.Digest {
+				found = true
+				break
+			}
+		}
+		if !found {
+			skipped = append(skipped, layer)
+		}
+	}
+	return skipped
+}
+
+// Close releases the resources of the image like temporary files
+func (img *Image) Close() error {
+	return img.fs.Image().Close()
+}
+
+// Info describes the image, e.g. its digest
+func (img *Image) Info() ImageInfo {
+	return img.info
+}
+
+// Layers returns the merged layers and how long it took to pull them
+func (img *Image) Layers() []LayerInfo {
+	durations := img.fs.Durations()
+
+	layers := []LayerInfo{}
+	for i, layer := range img.fs.Layers() {
+		layers = append(layers, LayerInfo{
+			Digest:     layer.Digest,
+			MediaType:  layer.MediaType,
+			Size:       layer.Size,
+			DurationMs: int64(durations[i] / time.Millisecond),
+		})
+	}
+	return layers
+}
+
+// FS returns the merged filesystem for lower level access like the tar index
+func (img *Image) FS() *rootfs.FS {
+	return img.fs
+}
+
+// Resolve returns the entry at the path following all symlinks. With IncludeDeleted, the last
+// version of deleted paths is returned as well.
+func (img *Image) Resolve(p string) (*dianatar.Entry, error) {
+	entry, err := img.fs.Resolve(p)
+	if err == nil || !img.opts.IncludeDeleted {
+		return entry, err
+	}
+
+	versions := img.fs.Index().Versions(p)
+	if len(versions) == 0 {
+		return nil, err
+	}
+	return versions[len(versions)-1].Entry, nil
+}
@@ -0,0 +1,315 @@
+package diana
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/ldd"
+	"github.com/cedrickring/diana/pkg/rootfs"
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Result describes the image and the files written by Extract
+type Result struct {
+	Image  ImageInfo   `json:"image"`
+	Layers []LayerInfo `json:"layers"`
+	Files  []File      `json:"files"`
+}
+
+// File is a file, directory or symlink written by Extract
+type File struct {
+	// Path is the path of the file in the image
+	Path        string `json:"path,omitempty"`
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Mode        string `json:"mode,omitempty"`
+	Size        int64  `json:"size"`
+	// SHA256 is the hex encoded checksum of regular files
+	SHA256   string `json:"sha256,omitempty"`
+	Linkname string `json:"linkname,omitempty"`
+}
+
+func newFile(e *dianatar.Entry, destination string, sha256 string) File {
+	return File{
+		Path:        e.Path,
+		Destination: destination,
+		Type:        FileType(e.Header),
+		Mode:        e.Header.FileInfo().Mode().String(),
+		Size:        e.Header.Size,
+		SHA256:      sha256,
+		Linkname:    e.Header.Linkname,
+	}
+}
+
+// FileType names the type of the file: directory, symlink, hardlink, file or other
+func FileType(h *tar.Header) string {
+	switch h.Typeflag {
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	}
+	return "other"
+}
+
+// Extract pulls the image and writes the files or directories at paths into the output
+// directory, keeping their base names. On errors, the result holds the files written so far.
+func Extract(ctx context.Context, reference string, paths []string, opts Options) (Result, error) {
+	img, err := Open(ctx, reference, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer img.Close()
+
+	return img.Extract(ctx, paths)
+}
+
+// Extract writes the files or directories at paths into the output directory, keeping their
+// base names. On errors, the result holds the files written so far.
+func (img *Image) Extract(ctx context.Context, paths []string) (Result, error) {
+	x := &extraction{tree: img, dir: img.opts.OutputDir}
+	if x.dir == "" {
+		x.dir = "."
+	}
+	if img.opts.WithDeps {
+		x.deps = img.fs
+	}
+
+	result := func() Result {
+		return Result{Image: img.Info(), Layers: img.Layers(), Files: x.files}
+	}
+	for _, p := range paths {
+		if err := x.extract(ctx, p, ""); err != nil {
+			return result(), errors.Wrapf(err, "extracting %s", p)
+		}
+	}
+	return result(), nil
+}
+
+// ExtractTo writes the file or directory at name to the target path instead of keeping its
+// base name, e.g. to rename it. OutputDir and WithDeps aren't applied.
+func (img *Image) ExtractTo(ctx context.Context, name string, target string) ([]File, error) {
+	return extractTo(ctx, img, name, target)
+}
+
+// ExtractTo writes the file or directory at name to the target path, pulling only the layers
+// needed for it
+func (f *FS) ExtractTo(ctx context.Context, name string, target string) ([]File, error) {
+	return extractTo(ctx, f, name, target)
+}
+
+func extractTo(ctx context.Context, t tree, name string, target string) ([]File, error) {
+	x := &extraction{tree: t}
+	if err := x.extract(ctx, name, target); err != nil {
+		return x.files, errors.Wrapf(err, "extracting %s", name)
+	}
+	return x.files, nil
+}
+
+type extraction struct {
+	tree tree
+	//deps is the filesystem to resolve shared libraries in, nil unless they're extracted as well
+	deps  *rootfs.FS
+	dir   string
+	files []File
+}
+
+// extract writes the file or directory to the target, into the output dir keeping its base
+// name if the target is empty
+func (x *extraction) extract(ctx context.Context, fileName string, target string) error {
+	entry, err := x.tree.resolve(ctx, dianatar.CleanPath(fileName))
+	if os.IsNotExist(errors.Cause(err)) {
+		return errors.Wrapf(err, `the file "%v" doesn't exist in the image`, fileName)
+	} else if err != nil {
+		return err
+	}
+
+	if x.deps != nil && !entry.IsDir() {
+		return x.extractWithDeps(ctx, fileName)
+	}
+
+	if target == "" {
+		base := path.Base(entry.Path)
+		if base == "/" {
+			base = "rootfs"
+		}
+		target = filepath.Join(x.dir, base)
+	}
+
+	if !entry.IsDir() {
+		if err := x.writeEntry(ctx, entry, filepath.Dir(target), target); err != nil {
+			return err
+		}
+		logrus.Infof("Extracted file to %s", target)
+		return nil
+	}
+
+	if err := os.MkdirAll(target, os.ModePerm); err != nil {
+		return err
+	}
+	descendants, err := x.tree.descendants(ctx, entry)
+	if err != nil {
+		return err
+	}
+	for _, e := range descendants {
+		rel := strings.TrimPrefix(e.Path, strings.TrimSuffix(entry.Path, "/")+"/")
+		if err := x.writeEntry(ctx, e, target, filepath.Join(target, filepath.FromSlash(rel))); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Extracted directory to %s", target)
+	return nil
+}
+
+// writeEntry writes the entry to the target below the root directory
+func (x *extraction) writeEntry(ctx context.Context, e *dianatar.Entry, root string, target string) error {
+	if err := checkTarget(root, target); err != nil {
+		return err
+	}
+	mode := os.FileMode(e.Header.Mode).Perm()
+
+	switch {
+	case e.IsDir():
+		x.files = append(x.files, newFile(e, target, ""))
+		return os.MkdirAll(target, mode|0700)
+	case e.IsSymlink():
+		x.files = append(x.files, newFile(e, target, ""))
+		os.Remove(target)
+		return os.Symlink(e.Header.Linkname, target)
+	case !e.HasContent():
+		logrus.Debugf("Skipping %s, it's neither a regular file, directory nor symlink", e.Path)
+		return nil
+	}
+
+	rc, err := x.tree.open(ctx, e)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		//replace the link instead of writing to wherever it points to
+		os.Remove(target)
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return errors.Wrap(err, "creating target file")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), rc); err != nil {
+		return errors.Wrapf(err, "writing %s", target)
+	}
+	x.files = append(x.files, newFile(e, target, hex.EncodeToString(hash.Sum(nil))))
+	return nil
+}
+
+// extractWithDeps writes the ELF binary and its shared library closure into the sysroot dir,
+// every file keeps its path of the image
+func (x *extraction) extractWithDeps(ctx context.Context, fileName string) error {
+	entries, err := ldd.Closure(ctx, x.deps, fileName)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		target := filepath.Join(x.dir, filepath.FromSlash(e.Path))
+		if err := checkTarget(x.dir, target); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+
+		if e.IsSymlink() && path.IsAbs(e.Header.Linkname) {
+			//absolute links would point to the host instead of the sysroot
+			link, err := filepath.Rel(path.Dir(e.Path), e.Header.Linkname)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			x.files = append(x.files, newFile(e, target, ""))
+			continue
+		}
+
+		if err := x.writeEntry(ctx, e, x.dir, target); err != nil {
+			return err
+		}
+		logrus.Debugf("Extracted %s", e.Path)
+	}
+
+	logrus.Infof("Extracted %s and %d files it depends on to %s", fileName, len(entries)-1, x.dir)
+	return nil
+}
+
+// checkTarget refuses targets outside of the root directory or below a symlink in it. Symlinks
+// written by earlier entries may point anywhere, e.g. a -> /etc followed by a/passwd.
+func checkTarget(root string, target string) error {
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.Errorf("%s is outside of %s", target, root)
+	}
+
+	dir := root
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.Errorf("refusing to write %s, %s is a symlink", target, dir)
+		}
+	}
+	return nil
+}
+
+// Entries returns the entries below dir sorted by path, only its direct children if not
+// recursive. With IncludeDeleted, deleted entries are returned as well.
+func (img *Image) Entries(dir string, recursive bool) []*dianatar.Entry {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	all := img.fs.Index().Entries()
+	if img.opts.IncludeDeleted {
+		all = append(all, img.fs.Index().Deleted()...)
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].Path < all[j].Path
+		})
+	}
+
+	var entries []*dianatar.Entry
+	for _, e := range all {
+		if e.Path == dir || !strings.HasPrefix(e.Path, prefix) {
+			continue
+		}
+		if recursive || path.Dir(e.Path) == dir {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,39 @@
+package diana
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTarget(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "out", "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	//a symlink written by an earlier entry of the image
+	if err := os.Symlink(outside, filepath.Join(root, "out", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{target: filepath.Join(root, "out", "dir", "file")},
+		{target: filepath.Join(root, "out", "new", "file")},
+		{target: filepath.Join(root, "out", "link")},
+		{target: filepath.Join(root, "out", "link", "passwd"), wantErr: true},
+		{target: filepath.Join(root, "out", "link", "dir", "passwd"), wantErr: true},
+		{target: filepath.Join(root, "..", "passwd"), wantErr: true},
+	}
+	for _, tt := range tests {
+		err := checkTarget(root, tt.target)
+		if tt.wantErr && err == nil {
+			t.Errorf("%s: expected writing the target to be refused", tt.target)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.target, err)
+		}
+	}
+}
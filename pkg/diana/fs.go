@@ -0,0 +1,395 @@
+package diana
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/source"
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+)
+
+// tree is the merged filesystem behind the fs.FS implementations
+type tree interface {
+	//resolve returns the entry at the absolute path following all symlinks
+	resolve(ctx context.Context, p string) (*dianatar.Entry, error)
+	children(ctx context.Context, dir *dianatar.Entry) ([]*dianatar.Entry, error)
+	//descendants returns all entries below the directory, parents before their children
+	descendants(ctx context.Context, dir *dianatar.Entry) ([]*dianatar.Entry, error)
+	open(ctx context.Context, e *dianatar.Entry) (io.ReadCloser, error)
+}
+
+// FS is an image as fs.FS which pulls layers only when they're needed, starting at the top
+// layer. Reading a file added by the last layer doesn't pull any other layer, while listing a
+// directory usually needs all layers. The base layer is always included.
+type FS struct {
+	ctx  context.Context
+	lazy *rootfs.Lazy
+	info ImageInfo
+}
+
+// OpenFS opens the image referenced with an optional transport prefix without pulling any
+// layer. The context is used for all layers pulled later on and the FS has to be closed to
+// release temporary files. FromLayer is honored, while IncludeDeleted isn't supported.
+func OpenFS(ctx context.Context, reference string, opts Options) (*FS, error) {
+	sourceOpts := opts.SourceOptions()
+	img, err := source.Open(ctx, reference, sourceOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.IncludeBaseLayer = true
+	layers, err := opts.SelectLayers(img.Manifest().Layers)
+	if err != nil {
+		img.Close()
+		return nil, err
+	}
+
+	return &FS{
+		ctx:  ctx,
+		lazy: rootfs.NewLazy(img, layers),
+		info: Describe(img, sourceOpts.Platform),
+	}, nil
+}
+
+// Close releases the resources of the image like temporary files
+func (f *FS) Close() error {
+	return f.lazy.Image().Close()
+}
+
+// WithContext returns a copy of the FS which pulls layers with ctx. The copy shares the image
+// and the layers read so far, so only the original FS is closed.
+func (f *FS) WithContext(ctx context.Context) *FS {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
+// Info describes the image, e.g. its digest
+func (f *FS) Info() ImageInfo {
+	return f.info
+}
+
+// Open implements fs.FS, see Image.Open
+func (f *FS) Open(name string) (fs.File, error) {
+	return openFile(f.ctx, f, name)
+}
+
+// Stat implements fs.StatFS
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return stat(f.ctx, f, name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return readDir(f.ctx, f, name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	return readFile(f.ctx, f, name)
+}
+
+// Location identifies the content of a file, it only changes with the content
+type Location struct {
+	// Layer is the digest of the layer holding the content
+	Layer string
+	// Path is the path of the content in the layer, which differs from the path of hardlinks
+	Path string
+}
+
+// Locate returns where the content of the file is stored following all symlinks, the location
+// is empty for files without content like directories
+func (f *FS) Locate(name string) (Location, error) {
+	entry, err := lookup(f.ctx, f, "locate", name)
+	if err != nil || !entry.HasContent() {
+		return Location{}, err
+	}
+	return Location{Layer: f.lazy.Layers()[entry.ContentLayer].Digest, Path: entry.ContentPath}, nil
+}
+
+func (f *FS) resolve(ctx context.Context, p string) (*dianatar.Entry, error) {
+	return f.lazy.Resolve(ctx, p)
+}
+
+func (f *FS) children(ctx context.Context, dir *dianatar.Entry) ([]*dianatar.Entry, error) {
+	return f.lazy.Children(ctx, dir.Path)
+}
+
+func (f *FS) descendants(ctx context.Context, dir *dianatar.Entry) ([]*dianatar.Entry, error) {
+	children, err := f.lazy.Children(ctx, dir.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*dianatar.Entry
+	for _, child := range children {
+		entries = append(entries, child)
+		if !child.IsDir() {
+			continue
+		}
+		below, err := f.descendants(ctx, child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, below...)
+	}
+	return entries, nil
+}
+
+func (f *FS) open(ctx context.Context, e *dianatar.Entry) (io.ReadCloser, error) {
+	return f.lazy.Open(ctx, e)
+}
+
+// Open implements fs.FS. Names are unrooted slash separated paths like "usr/bin/env" and
+// symlinks are followed, so the image can be used like any other filesystem, e.g. with
+// fs.WalkDir, fs.Glob or http.FS.
+func (img *Image) Open(name string) (fs.File, error) {
+	return openFile(context.Background(), img, name)
+}
+
+// Stat implements fs.StatFS
+func (img *Image) Stat(name string) (fs.FileInfo, error) {
+	return stat(context.Background(), img, name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (img *Image) ReadDir(name string) ([]fs.DirEntry, error) {
+	return readDir(context.Background(), img, name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (img *Image) ReadFile(name string) ([]byte, error) {
+	return readFile(context.Background(), img, name)
+}
+
+func (img *Image) resolve(_ context.Context, p string) (*dianatar.Entry, error) {
+	entry, err := img.Resolve(p)
+	if err != nil && p == "/" {
+		//the root directory always exists even if no layer adds it
+		return &dianatar.Entry{
+			Path:         "/",
+			Header:       &tar.Header{Name: "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(0, 0)},
+			ContentLayer: -1,
+		}, nil
+	}
+	return entry, err
+}
+
+func (img *Image) children(_ context.Context, dir *dianatar.Entry) ([]*dianatar.Entry, error) {
+	return img.Entries(dir.Path, false), nil
+}
+
+func (img *Image) descendants(_ context.Context, dir *dianatar.Entry) ([]*dianatar.Entry, error) {
+	return img.Entries(dir.Path, true), nil
+}
+
+func (img *Image) open(ctx context.Context, e *dianatar.Entry) (io.ReadCloser, error) {
+	return img.fs.Open(ctx, e)
+}
+
+// lookup resolves the name of the fs.FS
+func lookup(ctx context.Context, t tree, op string, name string) (*dianatar.Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, err := t.resolve(ctx, "/"+name)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return entry, nil
+}
+
+func openFile(ctx context.Context, t tree, name string) (fs.File, error) {
+	entry, err := lookup(ctx, t, "open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info := newFileInfo(entry, name)
+	if entry.IsDir() {
+		return &dir{ctx: ctx, tree: t, entry: entry, info: info}, nil
+	}
+	return &file{ctx: ctx, tree: t, entry: entry, info: info}, nil
+}
+
+func stat(ctx context.Context, t tree, name string) (fs.FileInfo, error) {
+	entry, err := lookup(ctx, t, "stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(entry, name), nil
+}
+
+func readDir(ctx context.Context, t tree, name string) ([]fs.DirEntry, error) {
+	entry, err := lookup(ctx, t, "readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	children, err := t.children(ctx, entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := []fs.DirEntry{}
+	for _, child := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.Header.FileInfo()))
+	}
+	return entries, nil
+}
+
+func readFile(ctx context.Context, t tree, name string) ([]byte, error) {
+	f, err := openFile(ctx, t, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// fileInfo is the info of the header with the name the file was opened with
+type fileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func newFileInfo(e *dianatar.Entry, name string) fs.FileInfo {
+	return &fileInfo{FileInfo: e.Header.FileInfo(), name: path.Base(name)}
+}
+
+func (f *fileInfo) Name() string {
+	return f.name
+}
+
+// file opens its content on the first read, so stating an opened file doesn't read the layer.
+// Seeking backwards reads the content again from the start.
+type file struct {
+	ctx     context.Context
+	tree    tree
+	entry   *dianatar.Entry
+	info    fs.FileInfo
+	content io.ReadCloser
+	read    int64 //bytes read from content
+	offset  int64 //position of the next read
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(b []byte) (int, error) {
+	if f.content == nil {
+		if !f.entry.HasContent() {
+			f.content = ioutil.NopCloser(strings.NewReader(""))
+		} else if content, err := f.tree.open(f.ctx, f.entry); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: err}
+		} else {
+			f.content = content
+		}
+		f.read = 0
+	}
+
+	if f.read < f.offset {
+		n, err := io.CopyN(ioutil.Discard, f.content, f.offset-f.read)
+		f.read += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.content.Read(b)
+	f.read += int64(n)
+	f.offset = f.read
+	return n, err
+}
+
+// Seek implements io.Seeker, which http.FileServer needs to serve files
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.Name(), Err: fs.ErrInvalid}
+	}
+
+	if offset < f.read && f.content != nil {
+		f.content.Close()
+		f.content = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.content == nil {
+		return nil
+	}
+	return f.content.Close()
+}
+
+type dir struct {
+	ctx     context.Context
+	tree    tree
+	entry   *dianatar.Entry
+	info    fs.FileInfo
+	entries []*dianatar.Entry
+	read    bool //whether the children have been listed
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile, the entries are sorted by name
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		children, err := d.tree.children(d.ctx, d.entry)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.Name(), Err: err}
+		}
+		d.entries = children
+		d.read = true
+	}
+
+	count := len(d.entries)
+	if n > 0 && n < count {
+		count = n
+	}
+	if n > 0 && count == 0 {
+		return nil, io.EOF
+	}
+
+	entries := []fs.DirEntry{}
+	for _, e := range d.entries[:count] {
+		entries = append(entries, fs.FileInfoToDirEntry(e.Header.FileInfo()))
+	}
+	d.entries = d.entries[count:]
+	return entries, nil
+}
@@ -0,0 +1,151 @@
+package diana
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cedrickring/diana/pkg/registry"
+)
+
+// testFile is an entry of a test layer, regular files unless the type is set
+type testFile struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+// writeLayout writes an OCI image layout holding a single image with the layers and returns
+// its reference
+func writeLayout(t *testing.T, layers ...[]testFile) string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	blob := func(content []byte) string {
+		digest := registry.Digest(content)
+		if err := ioutil.WriteFile(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return digest
+	}
+
+	manifest := registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}
+	for _, files := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, f := range files {
+			h := &tar.Header{Name: f.name, Typeflag: f.typeflag, Linkname: f.linkname, Mode: 0644}
+			switch f.typeflag {
+			case 0:
+				h.Typeflag = tar.TypeReg
+				h.Size = int64(len(f.content))
+			case tar.TypeDir:
+				h.Mode = 0755
+			}
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte(f.content))
+		}
+		tw.Close()
+
+		manifest.Layers = append(manifest.Layers, registry.Layer{MediaType: registry.MediaTypeOCILayer, Size: int64(buf.Len()), Digest: blob(buf.Bytes())})
+	}
+
+	platform := registry.DefaultPlatform()
+	config, _ := json.Marshal(map[string]interface{}{"os": platform.OS, "architecture": platform.Architecture, "rootfs": map[string]interface{}{"type": "layers"}})
+	manifest.Config = registry.ManifestConfig{MediaType: registry.MediaTypeOCIConfig, Size: int64(len(config)), Digest: blob(config)}
+
+	m, _ := json.Marshal(manifest)
+	index, _ := json.Marshal(registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{
+		{MediaType: registry.MediaTypeOCIManifest, Size: int64(len(m)), Digest: blob(m)},
+	}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return "oci:" + dir
+}
+
+var testLayers = [][]testFile{
+	{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/hostname", content: "base"},
+		{name: "etc/removed", content: "removed"},
+		{name: "bin/", typeflag: tar.TypeDir},
+		{name: "bin/busybox", content: "busybox"},
+		{name: "bin/sh", typeflag: tar.TypeLink, linkname: "bin/busybox"},
+		{name: "var/cache/", typeflag: tar.TypeDir},
+		{name: "var/cache/apk", content: "index"},
+	},
+	{
+		{name: "etc/.wh.removed"},
+		{name: "etc/hostname", content: "diana"},
+		{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "busybox"},
+		{name: "var/cache/.wh..wh..opq"},
+		//the parent directories only exist implicitly
+		{name: "usr/share/doc/app/README", content: "readme"},
+	},
+}
+
+var testFiles = []string{"etc/hostname", "bin/busybox", "bin/sh", "bin/ash", "usr/share/doc/app/README"}
+
+func TestFS(t *testing.T) {
+	fsys, err := OpenFS(context.Background(), writeLayout(t, testLayers...), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	if err := fstest.TestFS(fsys, testFiles...); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := fsys.ReadFile("etc/hostname"); err != nil || string(b) != "diana" {
+		t.Errorf("expected the hostname of the last layer, got %q, %v", b, err)
+	}
+	if b, err := fsys.ReadFile("bin/ash"); err != nil || string(b) != "busybox" {
+		t.Errorf("expected the symlink to be followed, got %q, %v", b, err)
+	}
+	for _, name := range []string{"etc/removed", "var/cache/apk"} {
+		if _, err := fsys.Stat(name); !stderrors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	if _, err := fsys.Open("/etc/hostname"); !stderrors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected rooted names to be invalid, got %v", err)
+	}
+
+	location, err := fsys.Locate("bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Path != "/bin/busybox" || location.Layer != fsys.lazy.Layers()[0].Digest {
+		t.Errorf("expected the hardlink to be located at /bin/busybox of the base layer, got %+v", location)
+	}
+}
+
+func TestImageFS(t *testing.T) {
+	img, err := Open(context.Background(), writeLayout(t, testLayers...), Options{IncludeBaseLayer: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+
+	if err := fstest.TestFS(img, testFiles...); err != nil {
+		t.Fatal(err)
+	}
+}
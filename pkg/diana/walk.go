@@ -0,0 +1,39 @@
+package diana
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+)
+
+// WalkFunc is called with the absolute path, info and content of a regular file
+type WalkFunc func(path string, info fs.FileInfo, content io.Reader) error
+
+// Walk pulls the image and calls fn for every regular file below root, see Image.Walk
+func Walk(ctx context.Context, reference string, root string, opts Options, fn WalkFunc) error {
+	img, err := Open(ctx, reference, opts)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	return img.Walk(ctx, root, fn)
+}
+
+// Walk calls fn for every regular file below root, reading each layer once at most. The files
+// are visited in the order of the layers which contain them, not sorted by path, and files
+// sharing their content (hardlinks) are only visited once. Use fs.WalkDir for all entries.
+func (img *Image) Walk(ctx context.Context, root string, fn WalkFunc) error {
+	root = dianatar.CleanPath(root)
+	prefix := strings.TrimSuffix(root, "/") + "/"
+
+	match := func(e *dianatar.Entry) bool {
+		return e.Path == root || strings.HasPrefix(e.Path, prefix)
+	}
+	return img.fs.WalkFiles(ctx, match, func(e *dianatar.Entry, content io.Reader) error {
+		return fn(e.Path, e.Header.FileInfo(), content)
+	})
+}
@@ -0,0 +1,351 @@
+package ldd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debug/elf"
+	"path"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const ldSoConf = "/etc/ld.so.conf"
+
+var (
+	defaultDirs64 = []string{"/lib64", "/usr/lib64", "/lib", "/usr/lib", "/usr/local/lib"}
+	defaultDirs32 = []string{"/lib", "/usr/lib", "/usr/local/lib"}
+)
+
+// object is an ELF file of the closure
+type object struct {
+	path    string
+	class   elf.Class
+	machine elf.Machine
+	interp  string
+	needed  []string
+	rpath   []string
+	runpath []string
+}
+
+// Closure returns the entries needed to run the ELF binary at file: the binary itself, its
+// interpreter, all shared libraries it needs directly or indirectly and every symlink which
+// is followed to reach them. Libraries which can't be found are logged, not returned as error.
+func Closure(ctx context.Context, fs *rootfs.FS, file string) ([]*tar.Entry, error) {
+	r := &resolver{
+		ctx:     ctx,
+		fs:      fs,
+		entries: map[string]*tar.Entry{},
+		loaded:  map[string]bool{},
+	}
+
+	bin, err := r.load(file)
+	if err != nil {
+		return nil, err
+	}
+	if bin == nil {
+		return nil, errors.Errorf("%s is not an ELF binary", file)
+	}
+	r.searchDirs = r.libraryDirs(bin.class)
+
+	if bin.interp != "" {
+		interp, err := r.load(bin.interp)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading interpreter %s", bin.interp)
+		}
+		if interp == nil {
+			return nil, errors.Errorf("interpreter %s is not an ELF file", bin.interp)
+		}
+	}
+
+	if err := r.loadNeeded(bin, nil); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*tar.Entry, 0, len(r.entries))
+	for _, e := range r.fs.Index().Entries() {
+		if _, ok := r.entries[e.Path]; ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+type resolver struct {
+	ctx        context.Context
+	fs         *rootfs.FS
+	searchDirs []string
+
+	entries map[string]*tar.Entry
+	//loaded contains the resolved paths of all loaded objects
+	loaded map[string]bool
+}
+
+// loadNeeded resolves the libraries needed by obj recursively. rpaths are the DT_RPATHs of
+// the objects which loaded obj, they are searched too as long as obj has no DT_RUNPATH.
+func (r *resolver) loadNeeded(obj *object, rpaths []string) error {
+	if len(obj.runpath) == 0 {
+		rpaths = append(append([]string{}, obj.rpath...), rpaths...)
+	} else {
+		rpaths = nil
+	}
+
+	for _, name := range obj.needed {
+		lib, found, err := r.find(obj, name, rpaths)
+		if err != nil {
+			return err
+		}
+		if !found {
+			logrus.Warnf("Shared library %s needed by %s not found", name, obj.path)
+			continue
+		}
+		if lib != nil {
+			if err := r.loadNeeded(lib, rpaths); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// find searches the library in the same order as the glibc dynamic linker. Libraries which
+// have been loaded already are reported as found, but without object.
+func (r *resolver) find(obj *object, name string, rpaths []string) (*object, bool, error) {
+	if strings.Contains(name, "/") {
+		return r.loadMatching(obj, name)
+	}
+
+	var dirs []string
+	dirs = append(dirs, r.expand(obj, rpaths)...)
+	dirs = append(dirs, r.expand(obj, obj.runpath)...)
+	dirs = append(dirs, r.searchDirs...)
+
+	for _, dir := range dirs {
+		lib, found, err := r.loadMatching(obj, path.Join(dir, name))
+		if err != nil || found {
+			return lib, found, err
+		}
+	}
+	return nil, false, nil
+}
+
+// loadMatching loads the library if it exists and matches the class and machine of obj
+func (r *resolver) loadMatching(obj *object, file string) (*object, bool, error) {
+	entry, err := r.fs.Resolve(file)
+	if err != nil || !entry.HasContent() {
+		return nil, false, nil
+	}
+	if r.loaded[entry.Path] {
+		r.addPath(file)
+		return nil, true, nil
+	}
+
+	lib, err := r.parse(file)
+	if err != nil || lib == nil {
+		return nil, false, err
+	}
+	if lib.class != obj.class || lib.machine != obj.machine {
+		logrus.Debugf("Skipping %s, it doesn't match the architecture of %s", file, obj.path)
+		return nil, false, nil
+	}
+
+	r.add(lib, file)
+	return lib, true, nil
+}
+
+// load parses the ELF file and adds it to the closure, nil is returned for non ELF files
+func (r *resolver) load(file string) (*object, error) {
+	obj, err := r.parse(file)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+
+	r.add(obj, file)
+	return obj, nil
+}
+
+func (r *resolver) add(obj *object, file string) {
+	r.loaded[obj.path] = true
+	r.addPath(file)
+}
+
+// parse reads the dependencies of the ELF file, nil is returned for non ELF files
+func (r *resolver) parse(file string) (*object, error) {
+	entry, err := r.fs.Resolve(file)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := r.fs.ReadFile(r.ctx, entry.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(content))
+	if err != nil {
+		logrus.Debugf("%s is not an ELF file: %v", file, err)
+		return nil, nil
+	}
+	defer f.Close()
+
+	obj := &object{
+		path:    entry.Path,
+		class:   f.Class,
+		machine: f.Machine,
+	}
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			interp, err := readInterp(prog)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading interpreter of %s", file)
+			}
+			obj.interp = interp
+		}
+	}
+
+	//statically linked binaries don't have a dynamic section
+	if f.Section(".dynamic") != nil || dynamicProg(f) {
+		if obj.needed, err = f.DynString(elf.DT_NEEDED); err != nil {
+			return nil, errors.Wrapf(err, "reading DT_NEEDED of %s", file)
+		}
+		obj.rpath = dynPaths(f, elf.DT_RPATH)
+		obj.runpath = dynPaths(f, elf.DT_RUNPATH)
+	}
+
+	return obj, nil
+}
+
+// addPath adds the file and all symlinks leading to it to the closure
+func (r *resolver) addPath(file string) {
+	entry, links, err := r.fs.Index().ResolveLinks(file)
+	if err != nil {
+		return
+	}
+	r.entries[entry.Path] = entry
+	for _, link := range links {
+		r.entries[link.Path] = link
+	}
+}
+
+// expand replaces $ORIGIN and $LIB in the search paths of obj
+func (r *resolver) expand(obj *object, dirs []string) []string {
+	lib := "lib"
+	if obj.class == elf.ELFCLASS64 {
+		lib = "lib64"
+	}
+
+	expanded := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		dir = strings.NewReplacer(
+			"${ORIGIN}", path.Dir(obj.path),
+			"$ORIGIN", path.Dir(obj.path),
+			"${LIB}", lib,
+			"$LIB", lib,
+		).Replace(dir)
+		expanded = append(expanded, dir)
+	}
+	return expanded
+}
+
+// libraryDirs returns the directories of ld.so.conf followed by the default directories
+func (r *resolver) libraryDirs(class elf.Class) []string {
+	dirs := r.readLdSoConf(ldSoConf, map[string]bool{})
+	if class == elf.ELFCLASS64 {
+		return append(dirs, defaultDirs64...)
+	}
+	return append(dirs, defaultDirs32...)
+}
+
+func (r *resolver) readLdSoConf(file string, seen map[string]bool) []string {
+	if seen[file] {
+		return nil
+	}
+	seen[file] = true
+
+	content, err := r.fs.ReadFile(r.ctx, file)
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "include "):
+			pattern := strings.TrimSpace(strings.TrimPrefix(line, "include "))
+			if !path.IsAbs(pattern) {
+				pattern = path.Join(path.Dir(file), pattern)
+			}
+			for _, include := range r.glob(pattern) {
+				dirs = append(dirs, r.readLdSoConf(include, seen)...)
+			}
+		case strings.HasPrefix(line, "hwcap "):
+			//hardware capability directories are optional
+		default:
+			dirs = append(dirs, strings.Fields(line)...)
+		}
+	}
+	return dirs
+}
+
+// glob returns the files of the image matching the pattern, only the last path component may
+// contain wildcards
+func (r *resolver) glob(pattern string) []string {
+	dir, err := r.fs.Resolve(path.Dir(pattern))
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, e := range r.fs.Index().Children(dir.Path) {
+		if ok, _ := path.Match(path.Base(pattern), path.Base(e.Path)); ok {
+			files = append(files, e.Path)
+		}
+	}
+	return files
+}
+
+func readInterp(prog *elf.Prog) (string, error) {
+	buf := make([]byte, prog.Filesz)
+	if _, err := prog.ReadAt(buf, 0); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(buf, "\x00")), nil
+}
+
+func dynamicProg(f *elf.File) bool {
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_DYNAMIC {
+			return true
+		}
+	}
+	return false
+}
+
+func dynPaths(f *elf.File, tag elf.DynTag) []string {
+	values, err := f.DynString(tag)
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, v := range values {
+		for _, dir := range strings.Split(v, ":") {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,237 @@
+package ldd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/source"
+)
+
+// elfFile builds a minimal 64 bit ELF file. It only has a dynamic section if it needs libraries
+// or has a DT_RUNPATH.
+func elfFile(machine elf.Machine, interp string, needed []string, runpath string) []byte {
+	type section struct {
+		name    string
+		typ     elf.SectionType
+		data    []byte
+		link    uint32
+		entsize uint64
+	}
+
+	var sections []section
+	if len(needed) > 0 || runpath != "" {
+		dynstr := []byte{0}
+		str := func(s string) uint64 {
+			off := len(dynstr)
+			dynstr = append(append(dynstr, s...), 0)
+			return uint64(off)
+		}
+		var dynamic bytes.Buffer
+		for _, lib := range needed {
+			binary.Write(&dynamic, binary.LittleEndian, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: str(lib)})
+		}
+		if runpath != "" {
+			binary.Write(&dynamic, binary.LittleEndian, elf.Dyn64{Tag: int64(elf.DT_RUNPATH), Val: str(runpath)})
+		}
+		binary.Write(&dynamic, binary.LittleEndian, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+
+		//.dynamic links to .dynstr, the first section after the null section
+		sections = append(sections,
+			section{name: ".dynstr", typ: elf.SHT_STRTAB, data: dynstr},
+			section{name: ".dynamic", typ: elf.SHT_DYNAMIC, data: dynamic.Bytes(), link: 1, entsize: 16},
+		)
+	}
+	sections = append(sections, section{name: ".shstrtab", typ: elf.SHT_STRTAB})
+	shstrtab := []byte{0}
+	names := make([]uint32, len(sections))
+	for i, s := range sections {
+		names[i] = uint32(len(shstrtab))
+		shstrtab = append(append(shstrtab, s.name...), 0)
+	}
+	sections[len(sections)-1].data = shstrtab
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections) + 1),
+		Shstrndx:  uint16(len(sections)),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if interp != "" {
+		header.Phoff, header.Phentsize, header.Phnum = 64, 56, 1
+	}
+
+	var data bytes.Buffer
+	offset := func() uint64 {
+		return uint64(64) + uint64(header.Phnum)*56 + uint64(data.Len())
+	}
+	var progs []elf.Prog64
+	if interp != "" {
+		size := uint64(len(interp) + 1)
+		progs = append(progs, elf.Prog64{Type: uint32(elf.PT_INTERP), Flags: uint32(elf.PF_R), Off: offset(), Filesz: size, Memsz: size, Align: 1})
+		data.WriteString(interp)
+		data.WriteByte(0)
+	}
+	headers := []elf.Section64{{}}
+	for i, s := range sections {
+		headers = append(headers, elf.Section64{Name: names[i], Type: uint32(s.typ), Off: offset(), Size: uint64(len(s.data)), Link: s.link, Addralign: 1, Entsize: s.entsize})
+		data.Write(s.data)
+	}
+	for data.Len()%8 != 0 {
+		data.WriteByte(0)
+	}
+	header.Shoff = offset()
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	for _, prog := range progs {
+		binary.Write(&buf, binary.LittleEndian, prog)
+	}
+	buf.Write(data.Bytes())
+	binary.Write(&buf, binary.LittleEndian, headers)
+	return buf.Bytes()
+}
+
+// testFile is an entry of the test image, symlinks if the link is set and regular files otherwise
+type testFile struct {
+	name    string
+	content []byte
+	link    string
+}
+
+// testImage is an image with a single uncompressed layer
+type testImage struct {
+	layer []byte
+}
+
+func (i *testImage) Reference() source.Reference {
+	return source.Reference{Transport: source.TransportOCI, Name: "test"}
+}
+
+func (i *testImage) Manifest() *registry.Manifest {
+	return &registry.Manifest{Layers: []registry.Layer{i.descriptor()}}
+}
+
+func (i *testImage) descriptor() registry.Layer {
+	return registry.Layer{MediaType: registry.MediaTypeOCILayer, Digest: registry.Digest(i.layer), Size: int64(len(i.layer))}
+}
+
+func (i *testImage) Config(context.Context) ([]byte, error) {
+	return nil, source.ErrNoConfig
+}
+
+func (i *testImage) Layer(context.Context, registry.Layer) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(i.layer)), nil
+}
+
+func (i *testImage) Close() error {
+	return nil
+}
+
+func loadTestImage(t *testing.T, files ...testFile) *rootfs.FS {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		h := &tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(f.content))}
+		if f.link != "" {
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, f.link, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(f.content)
+	}
+	tw.Close()
+
+	img := &testImage{layer: buf.Bytes()}
+	fs, err := rootfs.Load(context.Background(), img, []registry.Layer{img.descriptor()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func closure(t *testing.T, fs *rootfs.FS, file string) []string {
+	entries, err := Closure(context.Background(), fs, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestClosure(t *testing.T) {
+	const interp = "/lib64/ld-linux-x86-64.so.2"
+	fs := loadTestImage(t,
+		testFile{name: "bin/app", content: elfFile(elf.EM_X86_64, interp, []string{"libfoo.so.1", "libc.so.6", "libmissing.so"}, "$ORIGIN/../lib/app")},
+		testFile{name: "lib64", link: "lib"},
+		testFile{name: "lib/ld-linux-x86-64.so.2", content: elfFile(elf.EM_X86_64, "", nil, "")},
+		testFile{name: "lib/app/libfoo.so.1", link: "libfoo.so.1.2"},
+		testFile{name: "lib/app/libfoo.so.1.2", content: elfFile(elf.EM_X86_64, "", []string{"libbar.so", "libc.so.6"}, "")},
+		testFile{name: "etc/ld.so.conf", content: []byte("include ld.so.conf.d/*.conf\n")},
+		testFile{name: "etc/ld.so.conf.d/opt.conf", content: []byte("# libraries of /opt\n/opt/lib\n")},
+		//libraries of other architectures are skipped
+		testFile{name: "opt/lib/libbar.so", content: elfFile(elf.EM_AARCH64, "", nil, "")},
+		testFile{name: "opt/lib/libc.so.6", content: elfFile(elf.EM_X86_64, "", nil, "")},
+		testFile{name: "usr/lib/libbar.so", content: elfFile(elf.EM_X86_64, "", nil, "")},
+		testFile{name: "usr/lib/libunused.so", content: elfFile(elf.EM_X86_64, "", nil, "")},
+	)
+
+	expected := []string{
+		"/bin/app",
+		"/lib/app/libfoo.so.1",
+		"/lib/app/libfoo.so.1.2",
+		"/lib/ld-linux-x86-64.so.2",
+		"/lib64",
+		"/opt/lib/libc.so.6",
+		"/usr/lib/libbar.so",
+	}
+	if paths := closure(t, fs, "/bin/app"); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestClosureStaticBinary(t *testing.T) {
+	fs := loadTestImage(t,
+		testFile{name: "bin/static", content: elfFile(elf.EM_X86_64, "", nil, "")},
+		testFile{name: "bin/script", content: []byte("#!/bin/sh\n")},
+	)
+
+	if paths := closure(t, fs, "/bin/static"); !reflect.DeepEqual(paths, []string{"/bin/static"}) {
+		t.Errorf("expected only the binary, got %v", paths)
+	}
+	if _, err := Closure(context.Background(), fs, "/bin/script"); err == nil {
+		t.Error("expected a script not to be an ELF binary")
+	}
+	if _, err := Closure(context.Background(), fs, "/bin/missing"); err == nil {
+		t.Error("expected a missing binary to fail")
+	}
+}
+
+func TestClosureMissingInterpreter(t *testing.T) {
+	fs := loadTestImage(t,
+		testFile{name: "bin/app", content: elfFile(elf.EM_X86_64, "/lib/ld-musl-x86_64.so.1", nil, "")},
+	)
+
+	if _, err := Closure(context.Background(), fs, "/bin/app"); err == nil {
+		t.Error("expected a missing interpreter to fail")
+	}
+}
@@ -0,0 +1,126 @@
+package oci
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// BlobStore reads content addressable blobs stored as <dir>/<algorithm>/<hex>, like the
+// blobs of an OCI layout or a containerd content store
+type BlobStore struct {
+	dir string
+}
+
+func NewBlobStore(dir string) *BlobStore {
+	return &BlobStore{dir: dir}
+}
+
+// Image reads the image with the given manifest digest, picking the platform if the manifest is an index
+func (b *BlobStore) Image(digest string, platform registry.Platform) (*Image, error) {
+	manifest, err := b.readManifest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.IsIndex() {
+		d, err := manifest.FindPlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		if manifest, err = b.readManifest(d.Digest); err != nil {
+			return nil, err
+		}
+		if manifest.IsIndex() {
+			return nil, errors.Errorf("manifest %s for platform %s is a nested index", d.Digest, platform)
+		}
+	}
+
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+
+	config, err := b.readBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading image config")
+	}
+
+	return &Image{
+		blobs:    b,
+		manifest: manifest,
+		config:   config,
+	}, nil
+}
+
+func (b *BlobStore) readManifest(digest string) (*registry.Manifest, error) {
+	content, err := b.readBlob(digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading manifest")
+	}
+
+	manifest, err := registry.NewManifest(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding manifest")
+	}
+	if manifest.MediaType == "" && !manifest.IsIndex() {
+		manifest.MediaType = registry.MediaTypeOCIManifest
+	}
+	manifest.Digest = digest
+	return manifest, nil
+}
+
+func (b *BlobStore) readBlob(digest string) ([]byte, error) {
+	rc, err := b.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// Open opens the blob, its content is verified while reading. Only sha256 digests are
+// supported, which also keeps the path of the blob inside the store.
+func (b *BlobStore) Open(digest string) (io.ReadCloser, error) {
+	if err := registry.ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(digest, ":", 2)
+	f, err := os.Open(filepath.Join(b.dir, parts[0], parts[1]))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("blob %s not found in %s", digest, b.dir)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "opening blob %s", digest)
+	}
+	blob, err := registry.NewVerifyingReader(f, digest)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Image is a single image read from a blob store
+type Image struct {
+	blobs    *BlobStore
+	manifest *registry.Manifest
+	config   []byte
+}
+
+func (i *Image) Manifest() *registry.Manifest {
+	return i.manifest
+}
+
+func (i *Image) RawConfig() []byte {
+	return i.config
+}
+
+// Layer opens the layer blob with the given digest
+func (i *Image) Layer(digest string) (io.ReadCloser, error) {
+	return i.blobs.Open(digest)
+}
@@ -0,0 +1,73 @@
+package oci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+)
+
+func TestBlobStoreOpen(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	digest := registry.Digest([]byte("blob"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "sha256", strings.TrimPrefix(digest, "sha256:")), []byte("blob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	//a file outside of the store which must not be reachable
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewBlobStore(dir)
+	rc, err := store.Open(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != "blob" {
+		t.Errorf("expected blob, got %q, %v", b, err)
+	}
+
+	for _, d := range []string{
+		"sha256:" + strings.ToUpper(strings.TrimPrefix(digest, "sha256:")),
+		"sha512:" + strings.Repeat("a", 128),
+		"..:secret",
+		"sha256/..:secret",
+		"sha256:../secret",
+		"secret",
+	} {
+		if rc, err := store.Open(d); err == nil {
+			rc.Close()
+			t.Errorf("expected %q to be rejected", d)
+		}
+	}
+}
+
+func TestBlobStoreOpenVerifiesContent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	digest := registry.Digest([]byte("blob"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "sha256", strings.TrimPrefix(digest, "sha256:")), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := NewBlobStore(dir).Open(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := ioutil.ReadAll(rc); err == nil {
+		t.Error("expected tampered content to fail verification")
+	} else if _, ok := err.(*registry.DigestMismatchError); !ok {
+		t.Errorf("expected a DigestMismatchError, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package oci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+const (
+	indexFile      = "index.json"
+	layoutFile     = "oci-layout"
+	refAnnotation  = "org.opencontainers.image.ref.name"
+	blobsDirectory = "blobs"
+)
+
+// Layout is a directory in the OCI image layout format
+type Layout struct {
+	blobs *BlobStore
+	index *registry.Manifest
+}
+
+// OpenLayout reads the index of the OCI image layout in dir
+func OpenLayout(dir string) (*Layout, error) {
+	if _, err := os.Stat(filepath.Join(dir, layoutFile)); err != nil {
+		return nil, errors.Wrapf(err, "%s is not an OCI image layout", dir)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading OCI index")
+	}
+
+	index, err := registry.NewManifest(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding OCI index")
+	}
+	if len(index.Manifests) == 0 {
+		return nil, errors.New("OCI layout doesn't contain any images")
+	}
+
+	return &Layout{
+		blobs: NewBlobStore(filepath.Join(dir, blobsDirectory)),
+		index: index,
+	}, nil
+}
+
+// Image returns the image with the given ref name annotation, picking the platform if the
+// image is an index. If ref is empty, the layout must contain a single image.
+func (l *Layout) Image(ref string, platform registry.Platform) (*Image, error) {
+	descriptor, err := l.findDescriptor(ref)
+	if err != nil {
+		return nil, err
+	}
+	return l.blobs.Image(descriptor.Digest, platform)
+}
+
+func (l *Layout) findDescriptor(ref string) (*registry.Descriptor, error) {
+	if ref == "" {
+		if len(l.index.Manifests) > 1 {
+			return nil, errors.New("OCI layout contains multiple images, please specify a reference")
+		}
+		return &l.index.Manifests[0], nil
+	}
+
+	for i, d := range l.index.Manifests {
+		if d.Annotations[refAnnotation] == ref || d.Digest == ref {
+			return &l.index.Manifests[i], nil
+		}
+	}
+	return nil, errors.Errorf("image %s not found in OCI layout", ref)
+}
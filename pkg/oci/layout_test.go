@@ -0,0 +1,170 @@
+package oci
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+)
+
+// testLayout writes OCI image layouts, blobs are stored as they are added
+type testLayout struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestLayout(t *testing.T) *testLayout {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, blobsDirectory, "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, layoutFile), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &testLayout{t: t, dir: dir}
+}
+
+func (l *testLayout) blob(content []byte) string {
+	digest := registry.Digest(content)
+	if err := ioutil.WriteFile(filepath.Join(l.dir, blobsDirectory, "sha256", strings.TrimPrefix(digest, "sha256:")), content, 0644); err != nil {
+		l.t.Fatal(err)
+	}
+	return digest
+}
+
+func (l *testLayout) json(v interface{}) (string, int64) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return l.blob(b), int64(len(b))
+}
+
+// image adds a manifest with a single layer and returns its descriptor
+func (l *testLayout) image(layer string) registry.Descriptor {
+	config, configSize := l.json(map[string]interface{}{"rootfs": map[string]interface{}{"type": "layers"}})
+	digest, size := l.json(registry.Manifest{
+		SchemaVersion: 2,
+		Config:        registry.ManifestConfig{MediaType: registry.MediaTypeOCIConfig, Size: configSize, Digest: config},
+		Layers:        []registry.Layer{{MediaType: registry.MediaTypeOCILayer, Size: int64(len(layer)), Digest: l.blob([]byte(layer))}},
+	})
+	return registry.Descriptor{MediaType: registry.MediaTypeOCIManifest, Size: size, Digest: digest}
+}
+
+func (l *testLayout) index(descriptors ...registry.Descriptor) {
+	b, err := json.Marshal(registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: descriptors})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(l.dir, indexFile), b, 0644); err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+func readLayer(t *testing.T, img *Image) string {
+	rc, err := img.Layer(img.Manifest().Layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestLayoutImage(t *testing.T) {
+	l := newTestLayout(t)
+	amd64, arm64 := l.image("amd64"), l.image("arm64")
+	amd64.Platform = &registry.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &registry.Platform{OS: "linux", Architecture: "arm64"}
+
+	multiPlatform, size := l.json(registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{amd64, arm64}})
+	app := registry.Descriptor{MediaType: registry.MediaTypeOCIIndex, Size: size, Digest: multiPlatform,
+		Annotations: map[string]string{refAnnotation: "app"}}
+	single := l.image("single")
+	single.Annotations = map[string]string{refAnnotation: "single"}
+	l.index(app, single)
+
+	layout, err := OpenLayout(l.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref      string
+		platform registry.Platform
+		layer    string
+	}{
+		{ref: "app", platform: *amd64.Platform, layer: "amd64"},
+		{ref: "app", platform: *arm64.Platform, layer: "arm64"},
+		{ref: multiPlatform, platform: *arm64.Platform, layer: "arm64"},
+		//single platform images are used regardless of the platform
+		{ref: "single", platform: *arm64.Platform, layer: "single"},
+	}
+	for _, tt := range tests {
+		img, err := layout.Image(tt.ref, tt.platform)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.ref, tt.platform, err)
+			continue
+		}
+		if img.Manifest().MediaType != registry.MediaTypeOCIManifest {
+			t.Errorf("%s %s: expected an OCI manifest, got %s", tt.ref, tt.platform, img.Manifest().MediaType)
+		}
+		if layer := readLayer(t, img); layer != tt.layer {
+			t.Errorf("%s %s: expected layer %s, got %s", tt.ref, tt.platform, tt.layer, layer)
+		}
+	}
+
+	for _, ref := range []string{"", "missing"} {
+		if _, err := layout.Image(ref, *amd64.Platform); err == nil {
+			t.Errorf("expected %q not to select an image", ref)
+		}
+	}
+	if _, err := layout.Image("app", registry.Platform{OS: "linux", Architecture: "s390x"}); err == nil {
+		t.Error("expected a missing platform to fail")
+	}
+}
+
+func TestLayoutSingleImage(t *testing.T) {
+	l := newTestLayout(t)
+	l.index(l.image("layer"))
+
+	layout, err := OpenLayout(l.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := layout.Image("", registry.DefaultPlatform())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer := readLayer(t, img); layer != "layer" {
+		t.Errorf("expected layer, got %s", layer)
+	}
+}
+
+func TestOpenLayoutRejectsInvalidLayouts(t *testing.T) {
+	empty := newTestLayout(t)
+	empty.index()
+
+	missingLayout := newTestLayout(t)
+	missingLayout.index(missingLayout.image("layer"))
+	os.Remove(filepath.Join(missingLayout.dir, layoutFile))
+
+	missingIndex := newTestLayout(t)
+
+	for name, dir := range map[string]string{
+		"empty index":        empty.dir,
+		"missing oci-layout": missingLayout.dir,
+		"missing index":      missingIndex.dir,
+	} {
+		if _, err := OpenLayout(dir); err == nil {
+			t.Errorf("%s: expected the layout to be rejected", name)
+		}
+	}
+}
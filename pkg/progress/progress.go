@@ -0,0 +1,315 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	barWidth       = 24
+	redrawInterval = 200 * time.Millisecond
+)
+
+// Tracker follows the layer downloads of a registry client. On a terminal it draws a bar per
+// layer and one for all layers, otherwise the progress is logged periodically.
+type Tracker struct {
+	mu       sync.Mutex
+	terminal io.Writer //bars are drawn if not nil
+	interval time.Duration
+	ticker   *time.Ticker
+	stop     chan struct{}
+
+	active  []*download
+	started map[string]bool
+	lines   int //number of bar lines currently drawn
+	first   time.Time
+
+	stats   Stats
+	planned []registry.Layer
+	total   int64
+}
+
+type download struct {
+	layer registry.Layer
+	read  int64
+	start time.Time
+}
+
+// Stats summarizes the layers of a pull
+type Stats struct {
+	Downloaded       int64
+	DownloadedLayers int
+	// Skipped are the layers which weren't needed, like the base layer
+	Skipped       int64
+	SkippedLayers int
+	// Reused are the needed layers which didn't have to be downloaded, like cached ones
+	Reused       int64
+	ReusedLayers int
+}
+
+func (s Stats) String() string {
+	summary := fmt.Sprintf("Downloaded %s (%s), skipped %s (%s)",
+		util.FormatSize(s.Downloaded), layers(s.DownloadedLayers), util.FormatSize(s.Skipped), layers(s.SkippedLayers))
+	if s.ReusedLayers > 0 {
+		summary += fmt.Sprintf(", reused %s (%s) from the cache", util.FormatSize(s.Reused), layers(s.ReusedLayers))
+	}
+	return summary
+}
+
+func layers(n int) string {
+	if n == 1 {
+		return "1 layer"
+	}
+	return fmt.Sprintf("%d layers", n)
+}
+
+// NewBars creates a tracker drawing progress bars to the terminal
+func NewBars(terminal io.Writer) *Tracker {
+	return &Tracker{
+		terminal: terminal,
+		interval: redrawInterval,
+		started:  map[string]bool{},
+	}
+}
+
+// NewLogger creates a tracker logging the progress of running downloads every interval
+func NewLogger(interval time.Duration) *Tracker {
+	return &Tracker{
+		interval: interval,
+		started:  map[string]bool{},
+	}
+}
+
+// Plan announces the layers which are going to be pulled and the ones which are skipped
+func (t *Tracker) Plan(pull, skip []registry.Layer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.planned = append(t.planned, pull...)
+	for _, layer := range pull {
+		t.total += layer.Size
+	}
+	for _, layer := range skip {
+		t.stats.Skipped += layer.Size
+		t.stats.SkippedLayers++
+	}
+}
+
+func (t *Tracker) Started(layer registry.Layer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.first.IsZero() {
+		t.first = now
+	}
+	t.started[layer.Digest] = true
+	t.active = append(t.active, &download{layer: layer, start: now})
+
+	if t.ticker == nil {
+		t.ticker = time.NewTicker(t.interval)
+		t.stop = make(chan struct{})
+		go t.run(t.ticker, t.stop)
+	}
+}
+
+func (t *Tracker) Read(layer registry.Layer, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if d := t.find(layer); d != nil {
+		d.read += int64(n)
+	}
+	t.stats.Downloaded += int64(n)
+}
+
+func (t *Tracker) Finished(layer registry.Layer, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.find(layer)
+	if d == nil {
+		return
+	}
+	for i, active := range t.active {
+		if active == d {
+			t.active = append(t.active[:i], t.active[i+1:]...)
+			break
+		}
+	}
+	t.stats.DownloadedLayers++
+
+	if t.terminal == nil {
+		return
+	}
+	t.clear()
+	if err != nil {
+		fmt.Fprintf(t.terminal, "%s failed after %s\n", shortDigest(layer.Digest), util.FormatSize(d.read))
+	} else {
+		fmt.Fprintf(t.terminal, "%s pulled %s in %s\n", shortDigest(layer.Digest), util.FormatSize(d.read), formatDuration(time.Since(d.start)))
+	}
+	t.draw()
+}
+
+// Finish stops drawing and returns the statistics of all downloads since the last call
+func (t *Tracker) Finish() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ticker != nil {
+		t.ticker.Stop()
+		close(t.stop)
+		t.ticker = nil
+	}
+	if t.terminal != nil {
+		t.clear()
+	}
+
+	stats := t.stats
+	for _, layer := range t.planned {
+		if !t.started[layer.Digest] {
+			stats.Reused += layer.Size
+			stats.ReusedLayers++
+		}
+	}
+
+	t.stats = Stats{}
+	t.planned = nil
+	t.total = 0
+	t.first = time.Time{}
+	t.started = map[string]bool{}
+	return stats
+}
+
+// Writer wraps the writer of log messages, so they are written above the progress bars
+func (t *Tracker) Writer(w io.Writer) io.Writer {
+	if t.terminal == nil {
+		return w
+	}
+	return &logWriter{tracker: t, out: w}
+}
+
+func (t *Tracker) run(ticker *time.Ticker, stop chan struct{}) {
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			if t.terminal != nil {
+				t.clear()
+				t.draw()
+			} else {
+				t.log()
+			}
+			t.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (t *Tracker) find(layer registry.Layer) *download {
+	for _, d := range t.active {
+		if d.layer.Digest == layer.Digest {
+			return d
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) log() {
+	for _, d := range t.active {
+		logrus.Infof("Pulling layer %s: %s", shortDigest(d.layer.Digest), status(d.read, d.layer.Size, time.Since(d.start)))
+	}
+}
+
+// clear removes the drawn bars, the cursor is at the start of the line below them
+func (t *Tracker) clear() {
+	if t.lines > 0 {
+		fmt.Fprintf(t.terminal, "\033[%dA\033[J", t.lines)
+		t.lines = 0
+	}
+}
+
+func (t *Tracker) draw() {
+	var lines []string
+	for _, d := range t.active {
+		lines = append(lines, fmt.Sprintf("%-12s %s %s", shortDigest(d.layer.Digest), bar(d.read, d.layer.Size), status(d.read, d.layer.Size, time.Since(d.start))))
+	}
+	if t.total > 0 && !t.first.IsZero() {
+		lines = append(lines, fmt.Sprintf("%-12s %s %s", "total", bar(t.stats.Downloaded, t.total), status(t.stats.Downloaded, t.total, time.Since(t.first))))
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(t.terminal, line)
+	}
+	t.lines = len(lines)
+}
+
+type logWriter struct {
+	tracker *Tracker
+	out     io.Writer
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.tracker.mu.Lock()
+	defer l.tracker.mu.Unlock()
+
+	l.tracker.clear()
+	n, err := l.out.Write(p)
+	l.tracker.draw()
+	return n, err
+}
+
+func bar(read, total int64) string {
+	if total <= 0 {
+		return "[" + strings.Repeat(" ", barWidth) + "]"
+	}
+
+	filled := int(float64(barWidth) * float64(read) / float64(total))
+	if filled > barWidth {
+		filled = barWidth
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]"
+}
+
+// status formats the bytes read, the rate and the estimated remaining time
+func status(read, total int64, elapsed time.Duration) string {
+	rate := float64(read) / elapsed.Seconds()
+
+	s := util.FormatSize(read)
+	if total > 0 {
+		s += " / " + util.FormatSize(total)
+	}
+	if elapsed < time.Second {
+		return s
+	}
+
+	s += fmt.Sprintf("  %s/s", util.FormatSize(int64(rate)))
+	if total > read && rate > 0 {
+		eta := time.Duration(float64(total-read) / rate * float64(time.Second))
+		s += "  ETA " + (eta + time.Second - 1).Truncate(time.Second).String()
+	}
+	return s
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
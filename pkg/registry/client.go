@@ -1,25 +1,214 @@
 package registry
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 const (
-	manifestURL           = "https://%s/v2/%s/manifests/%s"
-	manifestVersionHeader = "application/vnd.docker.distribution.manifest.v2+json"
-	layerURL              = "https://%s/v2/%s/blobs/%s"
+	manifestURL = "https://%s/v2/%s/manifests/%s"
+	blobURL     = "https://%s/v2/%s/blobs/%s"
 )
 
 var (
-	errAuthRequired = errors.New("authorization required")
+	// ErrAuthRequired is returned if the registry rejected the (missing) credentials
+	ErrAuthRequired = errors.New("authorization required")
+	// ErrNotFound is returned if the registry doesn't know the manifest or blob
+	ErrNotFound = errors.New("image not found")
 )
 
 type Client interface {
-	GetManifest(image string) (*Manifest, error)
-	PullLayer(image string, layer *Layer, out io.Writer) error
+	// GetManifest retrieves the image manifest the reference points to.
+	GetManifest(ctx context.Context, ref name.Reference) (*Manifest, error)
+	// GetBlob opens the blob with the given digest in the repository of the reference.
+	// The content is verified against the digest at EOF, the caller has to read it to EOF before
+	// trusting it and close the reader.
+	GetBlob(ctx context.Context, ref name.Reference, digest string) (io.ReadCloser, error)
+	// GetLayer opens the blob of the layer like GetBlob. Foreign layers are fetched from their urls.
+	GetLayer(ctx context.Context, ref name.Reference, layer Layer) (io.ReadCloser, error)
+}
+
+// authorizer adds the credentials for the given repository to the request
+type authorizer func(ctx context.Context, request *http.Request, repository string) error
+
+func newHTTPClient(transport http.RoundTripper) *http.Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{Transport: &loggingTransport{transport}}
+}
+
+func getManifest(ctx context.Context, client *http.Client, ref name.Reference, authorize authorizer) (*Manifest, error) {
+	repo := ref.Context()
+
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf(manifestURL, repo.RegistryStr(), repo.RepositoryStr(), ref.Identifier()), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating manifest request")
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Accept", acceptHeader())
+	if err := authorize(ctx, request, repo.RepositoryStr()); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Retrieving manifest for image %s", ref)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting v2 manifest")
+	}
+	defer response.Body.Close()
+
+	if err := checkResponseCode(response, "failed to get manifest"); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading manifest body")
+	}
+
+	manifest, err := NewManifest(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating new manifest")
+	}
+	if manifest.MediaType == "" {
+		//the media type is optional for OCI manifests
+		if mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type")); err == nil {
+			manifest.MediaType = mediaType
+		}
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+
+	//the manifest is only trusted if it matches the requested digest and the one the registry sent
+	manifest.Digest = Digest(b)
+	if d, ok := ref.(name.Digest); ok && d.DigestStr() != manifest.Digest {
+		return nil, &DigestMismatchError{Expected: d.DigestStr(), Actual: manifest.Digest}
+	}
+	if header := response.Header.Get("Docker-Content-Digest"); header != "" && header != manifest.Digest {
+		return nil, &DigestMismatchError{Expected: header, Actual: manifest.Digest}
+	}
+
+	return manifest, nil
+}
+
+// getBlob opens the blob, size is checked against the Content-Length of the response if it's known
+func getBlob(ctx context.Context, client *http.Client, ref name.Reference, digest string, size int64, authorize authorizer) (io.ReadCloser, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+	repo := ref.Context()
+
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf(blobURL, repo.RegistryStr(), repo.RepositoryStr(), digest), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating blob request")
+	}
+	request = request.WithContext(ctx)
+	if err := authorize(ctx, request, repo.RepositoryStr()); err != nil {
+		return nil, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "requesting blob with sha %s", digest)
+	}
+
+	if err := checkResponseCode(response, "failed to get blob"); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	if err := checkContentLength(response, size); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+
+	blob, err := NewVerifyingReader(response.Body, digest)
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	return blob, nil
+}
+
+func getLayer(ctx context.Context, client *http.Client, ref name.Reference, layer Layer, authorize authorizer) (io.ReadCloser, error) {
+	if !IsForeignLayer(layer.MediaType) || len(layer.URLs) == 0 {
+		return getBlob(ctx, client, ref, layer.Digest, layer.Size, authorize)
+	}
+
+	var lastErr error
+	for _, u := range layer.URLs {
+		blob, err := getForeignBlob(ctx, client, u, layer.Digest, layer.Size)
+		if err == nil {
+			return blob, nil
+		}
+		logrus.WithError(err).Debugf("Failed to fetch foreign layer from %s", u)
+		lastErr = err
+	}
+
+	//some registries serve foreign layers anyway
+	blob, err := getBlob(ctx, client, ref, layer.Digest, layer.Size, authorize)
+	if err != nil {
+		return nil, errors.Wrapf(lastErr, "fetching foreign layer %s", layer.Digest)
+	}
+	return blob, nil
+}
+
+func getForeignBlob(ctx context.Context, client *http.Client, rawURL string, digest string, size int64) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing foreign layer url")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.Errorf("unsupported foreign layer url %s", rawURL)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating foreign layer request")
+	}
+
+	logrus.Debugf("Fetching foreign layer %s from %s", digest, u)
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "requesting foreign layer from %s", u)
+	}
+
+	if err := checkResponseCode(response, "failed to get foreign layer"); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	if err := checkContentLength(response, size); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+
+	blob, err := NewVerifyingReader(response.Body, digest)
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	return blob, nil
+}
+
+// checkContentLength fails if the response is known to have another size than the blob. Sizes
+// of 0 are unknown, like the ones of schema1 layers.
+func checkContentLength(r *http.Response, size int64) error {
+	if size <= 0 || r.ContentLength < 0 || r.ContentLength == size {
+		return nil
+	}
+	return errors.Errorf("invalid content length, expected %d, got %d", size, r.ContentLength)
 }
 
 func checkResponseCode(r *http.Response, defaultMsg string) error {
@@ -27,9 +216,9 @@ func checkResponseCode(r *http.Response, defaultMsg string) error {
 	case http.StatusOK:
 		return nil
 	case http.StatusUnauthorized:
-		return errAuthRequired
+		return ErrAuthRequired
 	case http.StatusNotFound:
-		return errors.New("image not found")
+		return ErrNotFound
 	default:
 		return errors.New(defaultMsg)
 	}
@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+func newTestRegistry(t *testing.T, handler http.HandlerFunc) (Client, string) {
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+	return NewV2RegistryClient("", "", srv.Client().Transport), strings.TrimPrefix(srv.URL, "https://")
+}
+
+func parseReference(t *testing.T, s string) name.Reference {
+	ref, err := name.ParseReference(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestGetManifestVerifiesDigest(t *testing.T) {
+	tampered := strings.Replace(testManifest, `"size": 1`, `"size": 2`, 1)
+
+	tests := []struct {
+		name     string
+		body     string
+		header   string
+		byDigest bool
+		mismatch bool
+	}{
+		{name: "tag", body: testManifest},
+		{name: "tag with matching header", body: testManifest, header: Digest([]byte(testManifest))},
+		{name: "tag with other header", body: tampered, header: Digest([]byte(testManifest)), mismatch: true},
+		{name: "digest", body: testManifest, byDigest: true},
+		{name: "tampered manifest of digest", body: tampered, byDigest: true, mismatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, host := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set("Docker-Content-Digest", tt.header)
+				}
+				w.Header().Set("Content-Type", MediaTypeDockerManifest)
+				w.Write([]byte(tt.body))
+			})
+
+			reference := host + "/app:latest"
+			if tt.byDigest {
+				reference = host + "/app@" + Digest([]byte(testManifest))
+			}
+
+			manifest, err := client.GetManifest(context.Background(), parseReference(t, reference))
+			if tt.mismatch {
+				if _, ok := errors.Cause(err).(*DigestMismatchError); !ok {
+					t.Fatalf("expected a DigestMismatchError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if manifest.Digest != Digest([]byte(tt.body)) {
+				t.Errorf("expected the digest of the body, got %s", manifest.Digest)
+			}
+		})
+	}
+}
+
+func TestGetLayerChecksContentLength(t *testing.T) {
+	content := []byte("layer")
+	client, host := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	})
+	ref := parseReference(t, host+"/app:latest")
+
+	layer := Layer{MediaType: MediaTypeDockerLayer, Digest: Digest(content), Size: int64(len(content))}
+	rc, err := client.GetLayer(context.Background(), ref, layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rc.Close()
+
+	layer.Size++
+	if _, err := client.GetLayer(context.Background(), ref, layer); err == nil {
+		t.Error("expected a layer of another size to be rejected")
+	}
+}
@@ -1,23 +1,53 @@
 package registry
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
 
 type Manifest struct {
-	SchemaVersion int     `json:"schemaVersion"`
-	MediaType     string  `json:"mediaType"`
-	Layers        []Layer `json:"layers"`
+	SchemaVersion int            `json:"schemaVersion"`
+	MediaType     string         `json:"mediaType"`
+	Config        ManifestConfig `json:"config"`
+	Layers        []Layer        `json:"layers"`
+
+	//only set for manifest lists and OCI image indexes
+	Manifests []Descriptor `json:"manifests,omitempty"`
+
+	//digest of the manifest, empty if unknown
+	Digest string `json:"-"`
 }
 
 type ManifestConfig struct {
 	MediaType string `json:"mediaType"`
-	Size      int    `json:"size"`
+	Size      int64  `json:"size"`
 	Digest    string `json:"digest"`
 }
 
 type Layer struct {
 	MediaType string `json:"mediaType"`
-	Size      int    `json:"size"`
+	Size      int64  `json:"size"`
 	Digest    string `json:"digest"`
+	//only set for foreign layers which aren't served by the registry
+	URLs []string `json:"urls,omitempty"`
+}
+
+// Descriptor references a platform specific manifest of an index
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Digest    string    `json:"digest"`
+	Platform  *Platform `json:"platform,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// schema1Manifest is the legacy (signed) Docker manifest format
+type schema1Manifest struct {
+	FSLayers []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers"`
 }
 
 func NewManifest(buffer []byte) (*Manifest, error) {
@@ -25,5 +55,65 @@ func NewManifest(buffer []byte) (*Manifest, error) {
 	if err := json.Unmarshal(buffer, manifest); err != nil {
 		return nil, err
 	}
+
+	if manifest.SchemaVersion == 1 {
+		if err := manifest.fromSchema1(buffer); err != nil {
+			return nil, err
+		}
+	}
+
 	return manifest, nil
 }
+
+func (m *Manifest) fromSchema1(buffer []byte) error {
+	schema1 := schema1Manifest{}
+	if err := json.Unmarshal(buffer, &schema1); err != nil {
+		return errors.Wrap(err, "decoding schema1 manifest")
+	}
+
+	if m.MediaType == "" {
+		m.MediaType = MediaTypeDockerSchema1
+	}
+
+	//schema1 lists the layers from the top most to the base layer
+	m.Layers = make([]Layer, 0, len(schema1.FSLayers))
+	for i := len(schema1.FSLayers) - 1; i >= 0; i-- {
+		m.Layers = append(m.Layers, Layer{
+			MediaType: MediaTypeDockerLayer,
+			Digest:    schema1.FSLayers[i].BlobSum,
+		})
+	}
+
+	return nil
+}
+
+// IsIndex reports whether the manifest is a manifest list or OCI image index
+func (m *Manifest) IsIndex() bool {
+	return IsIndex(m.MediaType) || (m.MediaType == "" && len(m.Manifests) > 0)
+}
+
+// Validate checks that diana is able to handle the manifest and all of its layers
+func (m *Manifest) Validate() error {
+	switch {
+	case m.IsIndex():
+		return nil
+	case m.MediaType == "", m.MediaType == MediaTypeDockerManifest, m.MediaType == MediaTypeOCIManifest, IsSchema1(m.MediaType):
+	default:
+		return errors.Errorf("unsupported manifest media type %s", m.MediaType)
+	}
+
+	if len(m.Layers) == 0 {
+		return errors.New("manifest doesn't contain any layers")
+	}
+
+	for _, layer := range m.Layers {
+		if !supportedLayerTypes[layer.MediaType] {
+			return errors.Errorf("unsupported media type %s of layer %s", layer.MediaType, layer.Digest)
+		}
+		if layer.Digest == "" {
+			return errors.New("manifest contains a layer without digest")
+		}
+	}
+
+	return nil
+}
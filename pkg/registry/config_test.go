@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSchema1Manifest = `{
+  "schemaVersion": 1,
+  "name": "library/app",
+  "tag": "latest",
+  "architecture": "amd64",
+  "fsLayers": [
+    {"blobSum": "sha256:3333333333333333333333333333333333333333333333333333333333333333"},
+    {"blobSum": "sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+    {"blobSum": "sha256:1111111111111111111111111111111111111111111111111111111111111111"}
+  ],
+  "signatures": []
+}`
+
+func TestNewManifestReversesSchema1Layers(t *testing.T) {
+	manifest, err := NewManifest([]byte(testSchema1Manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.MediaType != MediaTypeDockerSchema1 {
+		t.Errorf("expected %s, got %s", MediaTypeDockerSchema1, manifest.MediaType)
+	}
+
+	var digests []string
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != MediaTypeDockerLayer {
+			t.Errorf("expected %s, got %s", MediaTypeDockerLayer, layer.MediaType)
+		}
+		digests = append(digests, layer.Digest)
+	}
+	expected := []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		"sha256:3333333333333333333333333333333333333333333333333333333333333333",
+	}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Errorf("expected the layers from the base layer up %v, got %v", expected, digests)
+	}
+
+	if err := manifest.Validate(); err != nil {
+		t.Errorf("expected the schema1 manifest to be valid: %v", err)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	layer := func(mediaType string) Layer {
+		return Layer{MediaType: mediaType, Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"}
+	}
+
+	tests := []struct {
+		name     string
+		manifest Manifest
+		valid    bool
+	}{
+		{name: "docker", manifest: Manifest{MediaType: MediaTypeDockerManifest, Layers: []Layer{layer(MediaTypeDockerLayer)}}, valid: true},
+		{name: "oci zstd", manifest: Manifest{MediaType: MediaTypeOCIManifest, Layers: []Layer{layer(MediaTypeOCILayerZstd)}}, valid: true},
+		{name: "foreign", manifest: Manifest{MediaType: MediaTypeDockerManifest, Layers: []Layer{layer(MediaTypeDockerForeignLayer)}}, valid: true},
+		{name: "index", manifest: Manifest{MediaType: MediaTypeOCIIndex}, valid: true},
+		{name: "no layers", manifest: Manifest{MediaType: MediaTypeDockerManifest}},
+		{name: "unknown manifest", manifest: Manifest{MediaType: "application/json", Layers: []Layer{layer(MediaTypeDockerLayer)}}},
+		{name: "unknown layer", manifest: Manifest{MediaType: MediaTypeOCIManifest, Layers: []Layer{layer("application/vnd.in-toto+json")}}},
+		{name: "layer without digest", manifest: Manifest{MediaType: MediaTypeOCIManifest, Layers: []Layer{{MediaType: MediaTypeOCILayer}}}},
+	}
+	for _, tt := range tests {
+		if err := tt.manifest.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
@@ -2,27 +2,34 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 const (
 	bearerAuthURL        = "https://auth.docker.io/token?service=registry.docker.io&scope=repository:%s:pull"
 	jwtAuthURL           = "https://hub.docker.com/v2/users/login/"
-	hubManifest          = "https://"
 	bearerAuth    string = "Bearer"
 )
 
+// defaultTokenLifetime is used if the token response doesn't contain expires_in,
+// tokens of the Docker token spec are valid for 60 seconds by default
+const defaultTokenLifetime = 60 * time.Second
+
+// tokenLeeway renews tokens a bit before they expire, so they don't expire in flight
+const tokenLeeway = 10 * time.Second
+
 type tokenResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
 }
 
 type jwtRequest struct {
@@ -30,142 +37,166 @@ type jwtRequest struct {
 	Password string `json:"password"`
 }
 
+// bearerToken is a token cached for a repository until it expires
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
 type DockerHubRegistryClient struct {
 	username string
 	password string
+	client   *http.Client
+
+	//tokenURL and loginURL are the endpoints of Docker Hub to get tokens from
+	tokenURL string
+	loginURL string
+
+	mu     sync.Mutex
+	tokens map[string]bearerToken
 }
 
-func NewDockerHubRegistryClient(username, password string) Client {
+// NewDockerHubRegistryClient creates a client for Docker Hub using bearer tokens.
+// If transport is nil, http.DefaultTransport is used.
+func NewDockerHubRegistryClient(username, password string, transport http.RoundTripper) Client {
 	return &DockerHubRegistryClient{
 		username: username,
 		password: password,
+		client:   newHTTPClient(transport),
+		tokenURL: bearerAuthURL,
+		loginURL: jwtAuthURL,
+		tokens:   map[string]bearerToken{},
 	}
 }
 
-func (d DockerHubRegistryClient) getBearerToken(repository string) (string, error) {
-	var resp *http.Response
-	var err error
-
-	resp, err = http.Get(fmt.Sprintf(bearerAuthURL, repository))
-	if err != nil {
-		return "", errors.Wrap(err, "getting bearer token")
+// getBearerToken returns the cached token of the repository or fetches a new one if it expired.
+// The lock isn't held while fetching, concurrent requests may fetch a token each.
+func (d *DockerHubRegistryClient) getBearerToken(ctx context.Context, repository string) (string, error) {
+	d.mu.Lock()
+	token, ok := d.tokens[repository]
+	d.mu.Unlock()
+	if ok && time.Now().Add(tokenLeeway).Before(token.expires) {
+		return token.token, nil
 	}
-	defer resp.Body.Close()
 
-	if err := checkResponseCode(resp, "failed to get bearer token"); err != nil {
-		if err == errAuthRequired { //try basic auth
-			req := jwtRequest{
-				Username: d.username,
-				Password: d.password,
-			}
-
-			buf := &bytes.Buffer{}
-			if err := json.NewEncoder(buf).Encode(req); err != nil {
-				return "", err
-			}
-
-			resp, err = http.Post(jwtAuthURL, "application/json", buf)
-			if err != nil {
-				return "", errors.Wrap(err, "getting jwt token from docker hub")
-			}
-
-			if err := checkResponseCode(resp, "failed to get bearer token"); err != nil {
-				return "", err
-			}
-		} else {
-			return "", err
-		}
+	token, err := d.fetchBearerToken(ctx, repository)
+	if err != nil {
+		return "", err
 	}
 
-	token := tokenResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return "", errors.Wrap(err, "decoding json response")
-	}
+	d.mu.Lock()
+	d.tokens[repository] = token
+	d.mu.Unlock()
 
-	return token.Token, nil
+	return token.token, nil
 }
 
-func (d DockerHubRegistryClient) GetManifest(image string) (*Manifest, error) {
-	tag, err := name.NewTag(image, name.WeakValidation)
+func (d *DockerHubRegistryClient) fetchBearerToken(ctx context.Context, repository string) (bearerToken, error) {
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf(d.tokenURL, repository), nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing image tag")
+		return bearerToken{}, errors.Wrap(err, "creating bearer token request")
 	}
 
-	bearer, err := d.getBearerToken(tag.RepositoryStr())
+	resp, err := d.client.Do(request.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		return bearerToken{}, errors.Wrap(err, "getting bearer token")
 	}
+	defer resp.Body.Close()
 
-	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf(manifestURL, tag.RegistryStr(), tag.RepositoryStr(), tag.TagStr()), nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "creating manifest request")
-	}
-	request.Header.Set("Accept", manifestVersionHeader)
-	request.Header.Set("Authorization", fmt.Sprintf("%s %s", bearerAuth, bearer))
+	if err := checkResponseCode(resp, "failed to get bearer token"); err != nil {
+		if err != ErrAuthRequired {
+			return bearerToken{}, err
+		}
+
+		//try basic auth
+		req := jwtRequest{
+			Username: d.username,
+			Password: d.password,
+		}
 
-	logrus.Infof("Retrieving manifest for image %s", image)
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(req); err != nil {
+			return bearerToken{}, err
+		}
 
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, errors.Wrap(err, "requesting v2 manifest")
-	}
-	defer response.Body.Close()
+		request, err := http.NewRequest(http.MethodPost, d.loginURL, buf)
+		if err != nil {
+			return bearerToken{}, errors.Wrap(err, "creating jwt token request")
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		resp, err = d.client.Do(request.WithContext(ctx))
+		if err != nil {
+			return bearerToken{}, errors.Wrap(err, "getting jwt token from docker hub")
+		}
+		defer resp.Body.Close()
 
-	if err := checkResponseCode(response, "failed to get manifest"); err != nil {
-		return nil, err
+		if err := checkResponseCode(resp, "failed to get bearer token"); err != nil {
+			return bearerToken{}, err
+		}
 	}
 
-	b, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading manifest body")
+	token := tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return bearerToken{}, errors.Wrap(err, "decoding json response")
 	}
 
-	manifest, err := NewManifest(b)
-	if err != nil {
-		return nil, errors.Wrap(err, "creating new manifest")
+	lifetime := defaultTokenLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
 	}
+	return bearerToken{token: token.Token, expires: time.Now().Add(lifetime)}, nil
+}
+
+// dropBearerToken removes the cached token of the repository, it returns whether there was one
+func (d *DockerHubRegistryClient) dropBearerToken(repository string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	return manifest, nil
+	_, ok := d.tokens[repository]
+	delete(d.tokens, repository)
+	return ok
 }
 
-func (d DockerHubRegistryClient) PullLayer(image string, layer *Layer, out io.Writer) error {
-	tag, err := name.NewTag(image, name.WeakValidation)
-	if err != nil {
-		return errors.Wrap(err, "parsing image tag")
+// withRetry calls fn once more with a new token if the registry rejected the cached one,
+// e.g. because it was revoked before it expired
+func (d *DockerHubRegistryClient) withRetry(ref name.Reference, fn func() error) error {
+	err := fn()
+	if errors.Cause(err) == ErrAuthRequired && d.dropBearerToken(ref.Context().RepositoryStr()) {
+		err = fn()
 	}
+	return err
+}
 
-	bearer, err := d.getBearerToken(tag.RepositoryStr())
+func (d *DockerHubRegistryClient) authorize(ctx context.Context, request *http.Request, repository string) error {
+	bearer, err := d.getBearerToken(ctx, repository)
 	if err != nil {
 		return err
 	}
-
-	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf(layerURL, tag.RegistryStr(), tag.RepositoryStr(), layer.Digest), nil)
-	if err != nil {
-		return errors.Wrap(err, "creating layer pull request")
-	}
 	request.Header.Set("Authorization", fmt.Sprintf("%s %s", bearerAuth, bearer))
+	return nil
+}
 
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return errors.Wrapf(err, "requesting layer with sha %s", layer.Digest)
-	}
-	defer response.Body.Close()
-
-	if err := checkResponseCode(response, "failed to get layer"); err != nil {
+func (d *DockerHubRegistryClient) GetManifest(ctx context.Context, ref name.Reference) (manifest *Manifest, err error) {
+	err = d.withRetry(ref, func() error {
+		manifest, err = getManifest(ctx, d.client, ref, d.authorize)
 		return err
-	}
-
-	length, _ := strconv.Atoi(response.Header.Get("Content-Length"))
-	if length != layer.Size {
-		return errors.Errorf("invalid content length, expected %d, got %d", layer.Size, length)
-	}
-
-	i, err := io.Copy(out, response.Body)
-	if err != nil {
-		return errors.Wrap(err, "writing layer response to out")
-	}
+	})
+	return manifest, err
+}
 
-	logrus.Debugf("Bytes written %v", i)
+func (d *DockerHubRegistryClient) GetBlob(ctx context.Context, ref name.Reference, digest string) (rc io.ReadCloser, err error) {
+	err = d.withRetry(ref, func() error {
+		rc, err = getBlob(ctx, d.client, ref, digest, -1, d.authorize)
+		return err
+	})
+	return rc, err
+}
 
-	return nil
+func (d *DockerHubRegistryClient) GetLayer(ctx context.Context, ref name.Reference, layer Layer) (rc io.ReadCloser, err error) {
+	err = d.withRetry(ref, func() error {
+		rc, err = getLayer(ctx, d.client, ref, layer, d.authorize)
+		return err
+	})
+	return rc, err
 }
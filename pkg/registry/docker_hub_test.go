@@ -0,0 +1,147 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+const testManifest = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": 2, "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},
+  "layers": [{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": 1, "digest": "sha256:ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"}]
+}`
+
+// fakeHub is a registry handing out numbered tokens, it only accepts the tokens in valid
+type fakeHub struct {
+	expiresIn int
+	rejectAll bool
+
+	mu        sync.Mutex
+	issued    int
+	valid     map[string]bool
+	manifests int
+}
+
+func (h *fakeHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		h.issued++
+		token := fmt.Sprintf("token-%d", h.issued)
+		h.valid[token] = true
+		fmt.Fprintf(w, `{"token": %q, "expires_in": %d}`, token, h.expiresIn)
+		return
+	}
+
+	h.manifests++
+	if h.rejectAll || !h.valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", MediaTypeDockerManifest)
+	fmt.Fprint(w, testManifest)
+}
+
+// revoke makes the registry reject all tokens issued so far
+func (h *fakeHub) revoke() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.valid = map[string]bool{}
+}
+
+func newTestHub(t *testing.T, hub *fakeHub) (*DockerHubRegistryClient, name.Reference) {
+	hub.valid = map[string]bool{}
+	srv := httptest.NewTLSServer(hub)
+	t.Cleanup(srv.Close)
+
+	client := NewDockerHubRegistryClient("", "", srv.Client().Transport).(*DockerHubRegistryClient)
+	client.tokenURL = srv.URL + "/token?scope=repository:%s:pull"
+	client.loginURL = srv.URL + "/login"
+
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "https://") + "/library/app:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, ref
+}
+
+func TestDockerHubCachesTokens(t *testing.T) {
+	hub := &fakeHub{expiresIn: 300}
+	client, ref := newTestHub(t, hub)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetManifest(context.Background(), ref); err != nil {
+			t.Fatalf("getting manifest: %v", err)
+		}
+	}
+	if hub.issued != 1 {
+		t.Errorf("expected 1 token to be issued, got %d", hub.issued)
+	}
+}
+
+func TestDockerHubRefreshesExpiredTokens(t *testing.T) {
+	//tokens expiring within tokenLeeway are renewed right away
+	hub := &fakeHub{expiresIn: 1}
+	client, ref := newTestHub(t, hub)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetManifest(context.Background(), ref); err != nil {
+			t.Fatalf("getting manifest: %v", err)
+		}
+	}
+	if hub.issued != 2 {
+		t.Errorf("expected 2 tokens to be issued, got %d", hub.issued)
+	}
+}
+
+func TestDockerHubRetriesRevokedTokens(t *testing.T) {
+	hub := &fakeHub{expiresIn: 300}
+	client, ref := newTestHub(t, hub)
+
+	if _, err := client.GetManifest(context.Background(), ref); err != nil {
+		t.Fatalf("getting manifest: %v", err)
+	}
+	hub.revoke()
+	if _, err := client.GetManifest(context.Background(), ref); err != nil {
+		t.Fatalf("getting manifest with a revoked token: %v", err)
+	}
+
+	if hub.issued != 2 {
+		t.Errorf("expected 2 tokens to be issued, got %d", hub.issued)
+	}
+	if hub.manifests != 3 {
+		t.Errorf("expected 3 manifest requests, got %d", hub.manifests)
+	}
+}
+
+func TestDockerHubRetriesOnce(t *testing.T) {
+	hub := &fakeHub{expiresIn: 300}
+	client, ref := newTestHub(t, hub)
+
+	if _, err := client.GetManifest(context.Background(), ref); err != nil {
+		t.Fatalf("getting manifest: %v", err)
+	}
+
+	//the registry rejects every token from now on
+	hub.mu.Lock()
+	hub.rejectAll = true
+	hub.mu.Unlock()
+
+	_, err := client.GetManifest(context.Background(), ref)
+	if errors.Cause(err) != ErrAuthRequired {
+		t.Fatalf("expected ErrAuthRequired, got %v", err)
+	}
+	if hub.manifests != 3 {
+		t.Errorf("expected 3 manifest requests, got %d", hub.manifests)
+	}
+}
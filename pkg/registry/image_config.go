@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// ImageConfig holds the parts of the image config (application/vnd.docker.container.image.v1+json
+// or application/vnd.oci.image.config.v1+json) diana needs
+type ImageConfig struct {
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant,omitempty"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History describes how a layer was created
+type History struct {
+	Created   string `json:"created,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+	//set for instructions which didn't create a layer, like ENV
+	EmptyLayer bool `json:"empty_layer,omitempty"`
+}
+
+func ParseImageConfig(content []byte) (*ImageConfig, error) {
+	var config ImageConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing image config")
+	}
+	return &config, nil
+}
+
+// LayerHistory returns the history of every layer in order, empty history is returned if the
+// history doesn't match the layers
+func (c *ImageConfig) LayerHistory(layers int) []History {
+	history := make([]History, 0, layers)
+	for _, h := range c.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+
+	if len(history) != layers {
+		return make([]History, layers)
+	}
+	return history
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// headers which carry credentials and are never logged
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// query parameters which carry credentials, e.g. of presigned blob urls
+var sensitiveParams = []string{"token", "signature", "sig", "x-amz-signature", "x-amz-credential", "x-amz-security-token"}
+
+// loggingTransport logs all requests and responses at trace level with credentials redacted
+type loggingTransport struct {
+	http.RoundTripper
+}
+
+func (l *loggingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !logrus.IsLevelEnabled(logrus.TraceLevel) {
+		return l.RoundTripper.RoundTrip(request)
+	}
+
+	logrus.WithField("headers", formatHeaders(request.Header)).Tracef("--> %s %s", request.Method, redactURL(request.URL))
+
+	start := time.Now()
+	response, err := l.RoundTripper.RoundTrip(request)
+	if err != nil {
+		logrus.Tracef("<-- %s %s failed after %s: %v", request.Method, redactURL(request.URL), time.Since(start), err)
+		return nil, err
+	}
+
+	logrus.WithField("headers", formatHeaders(response.Header)).Tracef("<-- %s %s %s (%s)", response.Status, request.Method, redactURL(request.URL), time.Since(start))
+	return response, nil
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User("REDACTED")
+	}
+
+	query := redacted.Query()
+	for key := range query {
+		for _, param := range sensitiveParams {
+			if strings.EqualFold(key, param) {
+				query.Set(key, "REDACTED")
+				redacted.RawQuery = query.Encode()
+			}
+		}
+	}
+
+	return redacted.String()
+}
+
+func formatHeaders(header http.Header) string {
+	var keys []string
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var headers []string
+	for _, key := range keys {
+		value := strings.Join(header[key], ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+			value = "REDACTED"
+		}
+		headers = append(headers, key+": "+value)
+	}
+	return strings.Join(headers, "; ")
+}
@@ -0,0 +1,63 @@
+package registry
+
+import "strings"
+
+const (
+	MediaTypeDockerManifest      = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeDockerConfig        = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer         = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer  = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCIManifest         = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex            = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig           = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer            = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip        = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd        = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeOCIForeignLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCIForeignLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeOCIForeignLayerZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+)
+
+// acceptedManifestTypes are sent to the registry in order of preference
+var acceptedManifestTypes = []string{
+	MediaTypeDockerManifest,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIIndex,
+	MediaTypeDockerSchema1Signed,
+	MediaTypeDockerSchema1,
+}
+
+var supportedLayerTypes = map[string]bool{
+	MediaTypeDockerLayer:         true,
+	MediaTypeDockerForeignLayer:  true,
+	MediaTypeOCILayer:            true,
+	MediaTypeOCILayerGzip:        true,
+	MediaTypeOCILayerZstd:        true,
+	MediaTypeOCIForeignLayer:     true,
+	MediaTypeOCIForeignLayerGzip: true,
+	MediaTypeOCIForeignLayerZstd: true,
+}
+
+func acceptHeader() string {
+	return strings.Join(acceptedManifestTypes, ", ")
+}
+
+// IsIndex reports whether the media type describes a manifest list or an OCI image index
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// IsSchema1 reports whether the media type describes a (signed) Docker schema1 manifest
+func IsSchema1(mediaType string) bool {
+	return mediaType == MediaTypeDockerSchema1 || mediaType == MediaTypeDockerSchema1Signed
+}
+
+// IsForeignLayer reports whether the layer may not be distributed by registries and has
+// to be fetched from its urls instead
+func IsForeignLayer(mediaType string) bool {
+	return mediaType == MediaTypeDockerForeignLayer || strings.Contains(mediaType, ".nondistributable.")
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// DefaultPlatform is linux on the architecture diana is running on
+func DefaultPlatform() Platform {
+	return Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// ParsePlatform parses platforms in the form os/arch[/variant]
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, errors.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Matches reports whether other satisfies p. An empty variant matches every variant.
+func (p Platform) Matches(other Platform) bool {
+	return p.OS == other.OS && p.Architecture == other.Architecture &&
+		(p.Variant == "" || p.Variant == other.Variant)
+}
+
+// ResolveManifest fetches the manifest of the reference. If it's an index, the manifest
+// of the given platform is fetched instead.
+func ResolveManifest(ctx context.Context, client Client, ref name.Reference, platform Platform) (*Manifest, error) {
+	manifest, err := client.GetManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.IsIndex() {
+		return manifest, nil
+	}
+
+	descriptor, err := manifest.FindPlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Using manifest %s for platform %s", descriptor.Digest, platform)
+
+	digest, err := name.NewDigest(ref.Context().Name()+"@"+descriptor.Digest, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing platform manifest digest")
+	}
+
+	manifest, err = client.GetManifest(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.IsIndex() {
+		return nil, errors.Errorf("manifest %s for platform %s is a nested index", descriptor.Digest, platform)
+	}
+	return manifest, nil
+}
+
+// FindPlatform returns the descriptor of the manifest matching the platform
+func (m *Manifest) FindPlatform(platform Platform) (*Descriptor, error) {
+	var available []string
+	for i := range m.Manifests {
+		d := &m.Manifests[i]
+		if d.Platform == nil {
+			continue
+		}
+		if platform.Matches(*d.Platform) {
+			return d, nil
+		}
+		available = append(available, d.Platform.String())
+	}
+	return nil, errors.Errorf("no manifest for platform %s, available: %s", platform, strings.Join(available, ", "))
+}
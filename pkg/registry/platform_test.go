@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testIndex(platforms ...Platform) Manifest {
+	index := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	for i := range platforms {
+		index.Manifests = append(index.Manifests, Descriptor{
+			MediaType: MediaTypeOCIManifest,
+			Digest:    fmt.Sprintf("sha256:%064d", i),
+			Platform:  &platforms[i],
+		})
+	}
+	return index
+}
+
+func TestFindPlatform(t *testing.T) {
+	index := testIndex(
+		Platform{OS: "linux", Architecture: "amd64"},
+		Platform{OS: "linux", Architecture: "arm", Variant: "v6"},
+		Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		Platform{OS: "windows", Architecture: "amd64"},
+	)
+
+	tests := []struct {
+		platform string
+		index    int
+	}{
+		{platform: "linux/amd64", index: 0},
+		{platform: "linux/arm/v7", index: 2},
+		//an empty variant matches the first manifest of every variant
+		{platform: "linux/arm", index: 1},
+		{platform: "windows/amd64", index: 3},
+		{platform: "linux/s390x", index: -1},
+	}
+	for _, tt := range tests {
+		platform, err := ParsePlatform(tt.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		descriptor, err := index.FindPlatform(platform)
+		if tt.index < 0 {
+			if err == nil {
+				t.Errorf("%s: expected no manifest, got %s", tt.platform, descriptor.Digest)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.platform, err)
+		} else if descriptor.Digest != index.Manifests[tt.index].Digest {
+			t.Errorf("%s: expected %s, got %s", tt.platform, index.Manifests[tt.index].Digest, descriptor.Digest)
+		}
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, s := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+	if p, err := ParsePlatform("linux/arm64/v8"); err != nil || p.String() != "linux/arm64/v8" {
+		t.Errorf("expected linux/arm64/v8, got %v, %v", p, err)
+	}
+}
+
+func TestResolveManifestSelectsPlatform(t *testing.T) {
+	amd64 := Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+
+	index := testIndex(amd64, arm64)
+	var requested []string
+	client, host := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			w.Header().Set("Content-Type", MediaTypeOCIIndex)
+			fmt.Fprintf(w, `{"schemaVersion": 2, "mediaType": %q, "manifests": [{"digest": %q, "platform": {"os": "linux", "architecture": "amd64"}}, {"digest": %q, "platform": {"os": "linux", "architecture": "arm64"}}]}`,
+				MediaTypeOCIIndex, index.Manifests[0].Digest, Digest([]byte(testManifest)))
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeDockerManifest)
+		w.Write([]byte(testManifest))
+	})
+
+	manifest, err := ResolveManifest(context.Background(), client, parseReference(t, host+"/app:latest"), arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.IsIndex() || manifest.Digest != Digest([]byte(testManifest)) {
+		t.Errorf("expected the arm64 manifest, got %s", manifest.Digest)
+	}
+	expected := "/v2/app/manifests/" + Digest([]byte(testManifest))
+	if len(requested) != 2 || requested[1] != expected {
+		t.Errorf("expected %s to be requested, got %v", expected, requested)
+	}
+
+	if _, err := ResolveManifest(context.Background(), client, parseReference(t, host+"/app:latest"), Platform{OS: "linux", Architecture: "s390x"}); err == nil {
+		t.Error("expected a missing platform to fail")
+	}
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Progress is notified about the layers downloaded by a client. The methods are called from
+// the goroutine reading the layer, so implementations have to be safe for concurrent use.
+type Progress interface {
+	// Started is called once the registry responded with the layer
+	Started(layer Layer)
+	// Read is called with the number of bytes read since the last call
+	Read(layer Layer, n int)
+	// Finished is called once when the layer has been closed, err is the first error
+	// reading it
+	Finished(layer Layer, err error)
+}
+
+type progressClient struct {
+	Client
+	progress Progress
+}
+
+// WithProgress wraps the client so the download of layers is reported to progress
+func WithProgress(client Client, progress Progress) Client {
+	if progress == nil {
+		return client
+	}
+	return &progressClient{
+		Client:   client,
+		progress: progress,
+	}
+}
+
+func (p *progressClient) GetLayer(ctx context.Context, ref name.Reference, layer Layer) (io.ReadCloser, error) {
+	blob, err := p.Client.GetLayer(ctx, ref, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	p.progress.Started(layer)
+	return &progressReader{ReadCloser: blob, layer: layer, progress: p.progress}, nil
+}
+
+type progressReader struct {
+	io.ReadCloser
+	layer    Layer
+	progress Progress
+
+	once sync.Once
+	err  error
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	if n > 0 {
+		p.progress.Read(p.layer, n)
+	}
+	if err != nil && err != io.EOF && p.err == nil {
+		p.err = err
+	}
+	return n, err
+}
+
+func (p *progressReader) Close() error {
+	err := p.ReadCloser.Close()
+	p.once.Do(func() {
+		p.progress.Finished(p.layer, p.err)
+	})
+	return err
+}
@@ -0,0 +1,239 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	stderrors "errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultCertsDir = "/etc/docker/certs.d"
+
+type TransportOptions struct {
+	//registries which are reached via plain http or https without certificate verification
+	InsecureRegistries []string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	//additional CA certificates of single registries by host
+	RegistryCAFiles map[string]string
+	//directory containing per registry certificates (<dir>/<host>/ca.crt, client.cert, client.key),
+	//defaults to /etc/docker/certs.d
+	CertsDir string
+}
+
+// Transport applies the TLS settings for every registry host. It's meant to be created
+// once and shared by all registry clients.
+type Transport struct {
+	insecure    map[string]bool
+	caFile      string
+	registryCAs map[string]string
+	clientCert  *tls.Certificate
+	certsDir    string
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+	plainHTTP  map[string]bool
+}
+
+func NewTransport(opts TransportOptions) (*Transport, error) {
+	t := &Transport{
+		insecure:    map[string]bool{},
+		caFile:      opts.CAFile,
+		registryCAs: opts.RegistryCAFiles,
+		certsDir:    opts.CertsDir,
+		transports:  map[string]*http.Transport{},
+		plainHTTP:   map[string]bool{},
+	}
+	if t.certsDir == "" {
+		t.certsDir = defaultCertsDir
+	}
+
+	for _, registry := range opts.InsecureRegistries {
+		t.insecure[registry] = true
+	}
+
+	if opts.CAFile != "" {
+		if err := appendCertFile(x509.NewCertPool(), opts.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, caFile := range opts.RegistryCAFiles {
+		if err := appendCertFile(x509.NewCertPool(), caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("both a client certificate and key have to be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		t.clientCert = &cert
+	}
+
+	return t, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	transport, err := t.transportFor(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Scheme != "https" || !t.isInsecure(host) {
+		return transport.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	plainHTTP := t.plainHTTP[host]
+	t.mu.Unlock()
+
+	if !plainHTTP {
+		resp, err := transport.RoundTrip(req)
+		//the error is usually wrapped, e.g. in a *net.OpError
+		var recordErr tls.RecordHeaderError
+		if !stderrors.As(err, &recordErr) {
+			return resp, err
+		}
+
+		//the registry doesn't speak TLS at all, fall back to plain http
+		logrus.Debugf("Registry %s doesn't support TLS, falling back to http", host)
+		t.mu.Lock()
+		t.plainHTTP[host] = true
+		t.mu.Unlock()
+	}
+
+	plain := *req
+	u := *req.URL
+	u.Scheme = "http"
+	plain.URL = &u
+	return transport.RoundTrip(&plain)
+}
+
+func (t *Transport) isInsecure(host string) bool {
+	if t.insecure[host] {
+		return true
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if t.insecure[hostname] || hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (t *Transport) transportFor(host string) (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if transport, ok := t.transports[host]; ok {
+		return transport, nil
+	}
+
+	tlsConfig, err := t.tlsConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          100,
+	}
+	t.transports[host] = transport
+
+	return transport, nil
+}
+
+func (t *Transport) tlsConfig(host string) (*tls.Config, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if t.caFile != "" {
+		if err := appendCertFile(pool, t.caFile); err != nil {
+			return nil, err
+		}
+	}
+	if caFile, ok := t.registryCAs[host]; ok {
+		if err := appendCertFile(pool, caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	config := &tls.Config{
+		RootCAs:            pool,
+		InsecureSkipVerify: t.isInsecure(host),
+	}
+
+	dir := filepath.Join(t.certsDir, host)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "reading certificates directory %s", dir)
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+
+		switch {
+		case strings.HasSuffix(f.Name(), ".crt"):
+			if err := appendCertFile(config.RootCAs, path); err != nil {
+				return nil, err
+			}
+			logrus.Debugf("Using CA %s for registry %s", path, host)
+
+		case strings.HasSuffix(f.Name(), ".cert"):
+			keyFile := strings.TrimSuffix(path, ".cert") + ".key"
+			cert, err := tls.LoadX509KeyPair(path, keyFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "loading client certificate %s", path)
+			}
+			config.Certificates = append(config.Certificates, cert)
+			logrus.Debugf("Using client certificate %s for registry %s", path, host)
+		}
+	}
+
+	if t.clientCert != nil {
+		config.Certificates = append(config.Certificates, *t.clientCert)
+	}
+
+	return config, nil
+}
+
+func appendCertFile(pool *x509.CertPool, file string) error {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "reading CA file %s", file)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.Errorf("no valid certificates found in %s", file)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePEM(t *testing.T, path, blockType string, b []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportFallsBackToPlainHTTP(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	transport, err := NewTransport(TransportOptions{InsecureRegistries: []string{host}, CertsDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://" + host + "/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+
+	if !transport.plainHTTP[host] {
+		t.Error("expected the registry to be remembered as plain http")
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestTransportLoadsCertsDir(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	//the certificate of the test server serves as CA and client certificate
+	certsDir := t.TempDir()
+	serverCert := srv.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(certsDir, host, "ca.crt"), "CERTIFICATE", srv.Certificate().Raw)
+	writePEM(t, filepath.Join(certsDir, host, "client.cert"), "CERTIFICATE", serverCert.Certificate[0])
+	writePEM(t, filepath.Join(certsDir, host, "client.key"), "PRIVATE KEY", key)
+
+	transport, err := NewTransport(TransportOptions{CertsDir: certsDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := transport.tlsConfig(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Certificate().Verify(x509.VerifyOptions{Roots: config.RootCAs}); err != nil {
+		t.Errorf("expected the CA of the certificates directory to be trusted: %v", err)
+	}
+	if len(config.Certificates) != 1 {
+		t.Errorf("expected the client certificate to be loaded, got %d certificates", len(config.Certificates))
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(srv.URL + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the client certificate to be presented, got %d", resp.StatusCode)
+	}
+}
+
+func TestTransportRejectsIncompleteCertsDir(t *testing.T) {
+	certsDir := t.TempDir()
+	writePEM(t, filepath.Join(certsDir, "registry.example.com", "client.cert"), "CERTIFICATE", []byte("cert"))
+
+	transport, err := NewTransport(TransportOptions{CertsDir: certsDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.tlsConfig("registry.example.com"); err == nil {
+		t.Error("expected a client certificate without key to be rejected")
+	}
+}
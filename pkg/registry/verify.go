@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const sha256Prefix = "sha256:"
+
+// DigestMismatchError is returned if content doesn't match its digest
+type DigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch, expected %s, got %s", e.Expected, e.Actual)
+}
+
+// ErrNotVerified is returned by Close if the content wasn't read to EOF and so couldn't be verified
+var ErrNotVerified = errors.New("content closed before it was verified")
+
+type verifyingReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	digest   string
+	verified bool
+}
+
+// NewVerifyingReader wraps the reader and fails at EOF if the content doesn't match the digest.
+// Only sha256 digests are supported. Content is only verified at EOF, so consumers have to read
+// it to EOF like tar.ForEach does, Close returns ErrNotVerified otherwise.
+func NewVerifyingReader(rc io.ReadCloser, digest string) (io.ReadCloser, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+	return &verifyingReader{
+		ReadCloser: rc,
+		hash:       sha256.New(),
+		digest:     digest,
+	}, nil
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if actual := sha256Prefix + hex.EncodeToString(v.hash.Sum(nil)); actual != v.digest {
+			return n, &DigestMismatchError{Expected: v.digest, Actual: actual}
+		}
+		v.verified = true
+	}
+
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	err := v.ReadCloser.Close()
+	if !v.verified {
+		return ErrNotVerified
+	}
+	return err
+}
+
+// ValidateDigest checks that the digest is a sha256 digest, the only algorithm diana verifies
+func ValidateDigest(digest string) error {
+	hexPart := strings.TrimPrefix(digest, sha256Prefix)
+	if hexPart == digest || len(hexPart) != sha256.Size*2 {
+		return errors.Errorf("unsupported digest %q, expected sha256", digest)
+	}
+	for _, c := range hexPart {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return errors.Errorf("invalid digest %q", digest)
+		}
+	}
+	return nil
+}
+
+// Digest returns the sha256 digest of the content
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return sha256Prefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	content := "layer content"
+
+	tests := []struct {
+		name    string
+		digest  string
+		wantErr bool
+	}{
+		{name: "matching digest", digest: Digest([]byte(content))},
+		{name: "other digest", digest: Digest([]byte("other content")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewVerifyingReader(ioutil.NopCloser(strings.NewReader(content)), tt.digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+
+			b, err := ioutil.ReadAll(rc)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(b) != content {
+					t.Errorf("expected %q, got %q", content, b)
+				}
+				return
+			}
+
+			mismatch, ok := err.(*DigestMismatchError)
+			if !ok {
+				t.Fatalf("expected a DigestMismatchError, got %v", err)
+			}
+			if mismatch.Expected != tt.digest || mismatch.Actual != Digest([]byte(content)) {
+				t.Errorf("unexpected digests in %v", mismatch)
+			}
+		})
+	}
+}
+
+func TestVerifyingReaderRejectsUnsupportedDigests(t *testing.T) {
+	for _, digest := range []string{
+		"sha512:" + strings.Repeat("a", 128),
+		"md5:d41d8cd98f00b204e9800998ecf8427e",
+		"sha256:" + strings.Repeat("A", 64),
+		"sha256:../../etc/passwd",
+		"",
+	} {
+		if _, err := NewVerifyingReader(ioutil.NopCloser(strings.NewReader("")), digest); err == nil {
+			t.Errorf("expected %q to be rejected", digest)
+		}
+	}
+}
+
+func TestVerifyingReaderCloseBeforeEOF(t *testing.T) {
+	content := "layer content"
+	rc, err := NewVerifyingReader(ioutil.NopCloser(strings.NewReader(content)), Digest([]byte(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rc.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != ErrNotVerified {
+		t.Errorf("expected ErrNotVerified, got %v", err)
+	}
+}
+
+func TestGetBlobVerifiesDigest(t *testing.T) {
+	blobs := map[string]string{
+		"/v2/app/blobs/" + Digest([]byte("blob")): "blob",
+		//a registry serving corrupted content
+		"/v2/app/blobs/" + Digest([]byte("original")): "tampered",
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blob, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(blob))
+	}))
+	defer srv.Close()
+
+	client := NewV2RegistryClient("", "", srv.Client().Transport)
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "https://") + "/app:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(digest string) (string, error) {
+		rc, err := client.GetBlob(context.Background(), ref, digest)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		return string(b), err
+	}
+
+	if blob, err := read(Digest([]byte("blob"))); err != nil || blob != "blob" {
+		t.Errorf("expected blob, got %q, %v", blob, err)
+	}
+	if _, err := read(Digest([]byte("original"))); err == nil {
+		t.Error("expected tampered blob to fail verification")
+	} else if _, ok := errors.Cause(err).(*DigestMismatchError); !ok {
+		t.Errorf("expected a DigestMismatchError, got %v", err)
+	}
+	if _, err := read(Digest([]byte("missing"))); errors.Cause(err) != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strings"
+
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+)
+
+// Export writes the merged filesystem as a single flattened tarball. Directories, symlinks
+// and special files are written first, followed by the content of every layer in one pass
+// each. Files sharing their content (hardlinks) are written once and linked afterwards.
+func (fs *FS) Export(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	for _, e := range fs.index.Entries() {
+		if e.Path == "/" || e.HasContent() {
+			continue
+		}
+		if err := tw.WriteHeader(exportHeader(e)); err != nil {
+			return errors.Wrapf(err, "writing %s", e.Path)
+		}
+	}
+
+	owners, links := fs.contentOwners()
+	err := fs.walkOwners(ctx, owners, nil, func(e *dianatar.Entry, content io.Reader) error {
+		h := exportHeader(e)
+		h.Typeflag = tar.TypeReg
+		h.Linkname = ""
+		if err := tw.WriteHeader(h); err != nil {
+			return errors.Wrapf(err, "writing %s", e.Path)
+		}
+		_, err := io.Copy(tw, content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range links {
+		h := exportHeader(e)
+		h.Typeflag = tar.TypeLink
+		h.Linkname = exportName(owners[contentRef{e.ContentLayer, e.ContentPath}])
+		h.Size = 0
+		if err := tw.WriteHeader(h); err != nil {
+			return errors.Wrapf(err, "writing %s", e.Path)
+		}
+	}
+
+	return tw.Close()
+}
+
+// exportHeader returns a copy of the header relative to the root of the tarball
+func exportHeader(e *dianatar.Entry) *tar.Header {
+	h := *e.Header
+	h.Name = exportName(e)
+	//let the writer pick a format which fits the header
+	h.Format = tar.FormatUnknown
+	return &h
+}
+
+func exportName(e *dianatar.Entry) string {
+	name := strings.TrimPrefix(e.Path, "/")
+	if e.IsDir() {
+		name += "/"
+	}
+	return name
+}
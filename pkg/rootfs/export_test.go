@@ -0,0 +1,155 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/pkg/errors"
+)
+
+// testFile is an entry of a test layer, regular files unless the type is set
+type testFile struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+// testImage serves layers built from test files, listed from the base layer up
+type testImage struct {
+	manifest *registry.Manifest
+	blobs    map[string][]byte
+}
+
+func newTestImage(t *testing.T, layers ...[]testFile) *testImage {
+	img := &testImage{manifest: &registry.Manifest{}, blobs: map[string][]byte{}}
+	for _, files := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, f := range files {
+			h := &tar.Header{Name: f.name, Typeflag: f.typeflag, Linkname: f.linkname, Mode: 0644}
+			switch f.typeflag {
+			case 0:
+				h.Typeflag = tar.TypeReg
+				h.Size = int64(len(f.content))
+			case tar.TypeDir:
+				h.Mode = 0755
+			}
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte(f.content))
+		}
+		tw.Close()
+
+		digest := registry.Digest(buf.Bytes())
+		img.blobs[digest] = buf.Bytes()
+		img.manifest.Layers = append(img.manifest.Layers, registry.Layer{
+			MediaType: registry.MediaTypeOCILayer,
+			Digest:    digest,
+			Size:      int64(buf.Len()),
+		})
+	}
+	return img
+}
+
+func (i *testImage) Reference() source.Reference {
+	return source.Reference{Transport: source.TransportOCI, Name: "test"}
+}
+
+func (i *testImage) Manifest() *registry.Manifest {
+	return i.manifest
+}
+
+func (i *testImage) Config(context.Context) ([]byte, error) {
+	return nil, source.ErrNoConfig
+}
+
+func (i *testImage) Layer(_ context.Context, layer registry.Layer) (io.ReadCloser, error) {
+	blob, ok := i.blobs[layer.Digest]
+	if !ok {
+		return nil, errors.Errorf("layer %s not found", layer.Digest)
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func (i *testImage) Close() error {
+	return nil
+}
+
+func loadTestImage(t *testing.T, layers ...[]testFile) *FS {
+	img := newTestImage(t, layers...)
+	fs, err := Load(context.Background(), img, img.manifest.Layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestExport(t *testing.T) {
+	fs := loadTestImage(t,
+		[]testFile{
+			{name: "etc/", typeflag: tar.TypeDir},
+			{name: "etc/hostname", content: "base"},
+			{name: "etc/removed", content: "removed"},
+			{name: "bin/", typeflag: tar.TypeDir},
+			{name: "bin/busybox", content: "busybox"},
+			{name: "bin/sh", typeflag: tar.TypeLink, linkname: "bin/busybox"},
+			{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "busybox"},
+		},
+		[]testFile{
+			{name: "etc/.wh.removed"},
+			//the last occurrence of a path within a layer wins, even with the same size
+			{name: "etc/hostname", content: "aaaa"},
+			{name: "etc/hostname", content: "bbbb"},
+		},
+	)
+
+	var buf bytes.Buffer
+	if err := fs.Export(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]*tar.Header{}
+	contents := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := headers[h.Name]; ok {
+			t.Errorf("%s exported twice", h.Name)
+		}
+		headers[h.Name] = h
+		b, _ := ioutil.ReadAll(tr)
+		contents[h.Name] = string(b)
+	}
+
+	if contents["etc/hostname"] != "bbbb" {
+		t.Errorf("expected the last version of etc/hostname, got %q", contents["etc/hostname"])
+	}
+	if _, ok := headers["etc/removed"]; ok {
+		t.Error("expected removed files not to be exported")
+	}
+	if h, ok := headers["etc/"]; !ok || h.Typeflag != tar.TypeDir {
+		t.Errorf("expected the directory etc/, got %v", h)
+	}
+	if h, ok := headers["bin/busybox"]; !ok || h.Typeflag != tar.TypeReg || contents["bin/busybox"] != "busybox" {
+		t.Errorf("expected the file bin/busybox, got %v", h)
+	}
+	if h, ok := headers["bin/sh"]; !ok || h.Typeflag != tar.TypeLink || h.Linkname != "bin/busybox" {
+		t.Errorf("expected bin/sh to be a hardlink to bin/busybox, got %v", h)
+	}
+	if h, ok := headers["bin/ash"]; !ok || h.Typeflag != tar.TypeSymlink || h.Linkname != "busybox" {
+		t.Errorf("expected bin/ash to be a symlink to busybox, got %v", h)
+	}
+}
@@ -0,0 +1,197 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Lazy is the merged filesystem of the layers of an image like FS, but layers are only read
+// when they're needed. Lookups start at the top layer and stop at the first layer determining
+// the path, so files added by the last layers don't need the lower layers at all.
+type Lazy struct {
+	image  source.Image
+	layers []registry.Layer
+
+	locks   []sync.Mutex
+	indexes []*dianatar.LayerIndex
+}
+
+// NewLazy creates the filesystem of the layers without reading any of them
+func NewLazy(image source.Image, layers []registry.Layer) *Lazy {
+	return &Lazy{
+		image:   image,
+		layers:  layers,
+		locks:   make([]sync.Mutex, len(layers)),
+		indexes: make([]*dianatar.LayerIndex, len(layers)),
+	}
+}
+
+func (l *Lazy) Image() source.Image {
+	return l.image
+}
+
+// Layers returns the layers of the filesystem from bottom to top
+func (l *Lazy) Layers() []registry.Layer {
+	return l.layers
+}
+
+// layer returns the index of the i-th layer, reading the layer on first use
+func (l *Lazy) layer(ctx context.Context, i int) (*dianatar.LayerIndex, error) {
+	l.locks[i].Lock()
+	defer l.locks[i].Unlock()
+
+	if l.indexes[i] != nil {
+		return l.indexes[i], nil
+	}
+
+	layer := l.layers[i]
+	logrus.Infof("Pulling layer %s (%d B)", layer.Digest, layer.Size)
+
+	blob, err := l.image.Layer(ctx, layer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pulling layer %s", layer.Digest)
+	}
+	defer blob.Close()
+
+	index, err := dianatar.ReadLayer(blob, layer.MediaType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading layer %s", layer.Digest)
+	}
+	l.indexes[i] = index
+	return index, nil
+}
+
+// Lookup returns the entry without following symlinks, nil if the path doesn't exist
+func (l *Lazy) Lookup(ctx context.Context, p string) (*dianatar.Entry, error) {
+	return l.lookup(ctx, dianatar.CleanPath(p), len(l.layers)-1)
+}
+
+// lookup returns the entry of the path as it is after the top layer
+func (l *Lazy) lookup(ctx context.Context, p string, top int) (*dianatar.Entry, error) {
+	implied := -1 //layer with files below the path but without its header
+	for i := top; i >= 0; i-- {
+		index, err := l.layer(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+
+		if header, ok := index.Header(p); ok {
+			return l.entry(ctx, i, header)
+		}
+		if implied < 0 && index.Contains(p) {
+			implied = i
+		}
+		if p != "/" && index.Hides(p) {
+			break
+		}
+	}
+
+	if implied < 0 && p != "/" {
+		return nil, nil
+	} else if implied < 0 {
+		implied = 0
+	}
+	//directories of layers which haven't been applied, like the base layer, or the root itself
+	return &dianatar.Entry{
+		Path:         p,
+		Header:       &tar.Header{Name: p, Typeflag: tar.TypeDir, Mode: 0755},
+		Layer:        implied,
+		ContentLayer: -1,
+	}, nil
+}
+
+func (l *Lazy) entry(ctx context.Context, i int, header *tar.Header) (*dianatar.Entry, error) {
+	h := *header
+	entry := &dianatar.Entry{
+		Path:         h.Name,
+		Header:       &h,
+		Layer:        i,
+		ContentLayer: -1,
+	}
+
+	switch h.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		entry.ContentLayer = i
+		entry.ContentPath = h.Name
+	case tar.TypeLink:
+		//hardlinks keep the content of the target as it was when the link was added
+		target, err := l.lookup(ctx, dianatar.CleanPath(h.Linkname), i)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil && target.HasContent() {
+			entry.ContentLayer = target.ContentLayer
+			entry.ContentPath = target.ContentPath
+			h.Size = target.Header.Size
+		}
+	}
+	return entry, nil
+}
+
+// Resolve returns the entry of the path following all symlinks
+func (l *Lazy) Resolve(ctx context.Context, p string) (*dianatar.Entry, error) {
+	entry, _, err := dianatar.ResolveFunc(p, func(p string) (*dianatar.Entry, error) {
+		return l.lookup(ctx, p, len(l.layers)-1)
+	})
+	return entry, err
+}
+
+// Children returns the direct children of the directory sorted by path. Unless a layer hides
+// the lower content of the directory, all layers have to be read.
+func (l *Lazy) Children(ctx context.Context, dir string) ([]*dianatar.Entry, error) {
+	dir = dianatar.CleanPath(dir)
+	top := len(l.layers) - 1
+
+	seen := map[string]bool{}
+	var children []*dianatar.Entry
+	for i := top; i >= 0; i-- {
+		index, err := l.layer(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range index.Children(dir) {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+
+			child, err := l.lookup(ctx, p, top)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				children = append(children, child)
+			}
+		}
+
+		if index.Opaque(dir) || dir != "/" && index.Hides(dir) {
+			break
+		}
+		if h, ok := index.Header(dir); ok && h.Typeflag != tar.TypeDir {
+			break
+		}
+	}
+
+	sort.Slice(children, func(a, b int) bool {
+		return children[a].Path < children[b].Path
+	})
+	return children, nil
+}
+
+// Open returns the content of a regular file or hardlink entry, the caller has to close it
+func (l *Lazy) Open(ctx context.Context, entry *dianatar.Entry) (io.ReadCloser, error) {
+	if !entry.HasContent() {
+		return nil, errors.Errorf("%s is not a regular file", entry.Path)
+	}
+	return openContent(ctx, l.image, l.layers[entry.ContentLayer], entry.ContentPath)
+}
@@ -0,0 +1,218 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// errFound stops reading a layer once the file has been found
+var errFound = errors.New("found")
+
+// FS is the merged filesystem of the layers of an image
+type FS struct {
+	image     source.Image
+	layers    []registry.Layer
+	index     *dianatar.Index
+	durations []time.Duration
+}
+
+// SelectLayers returns the layers to pull. Without the base layer, the first layer is
+// dropped as it's probably the base image.
+func SelectLayers(layers []registry.Layer, includeBaseLayer bool) []registry.Layer {
+	if !includeBaseLayer && len(layers) > 1 {
+		layers = layers[1:]
+	}
+	return layers
+}
+
+// FindLayer returns the position of the layer referenced by its index or (a prefix of) its digest
+func FindLayer(layers []registry.Layer, ref string) (int, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 0 || n >= len(layers) {
+			return 0, errors.Errorf("layer %d out of range, the image has %d layers", n, len(layers))
+		}
+		return n, nil
+	}
+
+	found := -1
+	for i, layer := range layers {
+		if strings.HasPrefix(layer.Digest, ref) || strings.HasPrefix(strings.TrimPrefix(layer.Digest, "sha256:"), ref) {
+			if found >= 0 && layers[found].Digest != layer.Digest {
+				return 0, errors.Errorf("layer %s is ambiguous", ref)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return 0, errors.Errorf("layer %s not found", ref)
+	}
+	return found, nil
+}
+
+// Prefetch downloads up to concurrency layers at once in the background, so Load doesn't have
+// to wait for each download in turn. Images which can't prefetch layers are left alone. The
+// returned stop cancels the downloads still running and waits for them, it has to be called
+// before the image is closed.
+func Prefetch(ctx context.Context, image source.Image, layers []registry.Layer, concurrency int) (stop func()) {
+	prefetcher, ok := image.(source.Prefetcher)
+	if !ok || concurrency < 2 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	jobs := make(chan registry.Layer)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for layer := range jobs {
+				if err := prefetcher.Prefetch(ctx, layer); err != nil {
+					//loading the layer retries the download and reports the error
+					logrus.WithError(err).Debugf("Failed to prefetch layer %s", layer.Digest)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, layer := range layers {
+			select {
+			case jobs <- layer:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// Load applies the layers of the image on top of each other
+func Load(ctx context.Context, image source.Image, layers []registry.Layer) (*FS, error) {
+	fs := &FS{
+		image:  image,
+		layers: layers,
+		index:  dianatar.NewIndex(),
+	}
+
+	for i, layer := range layers {
+		logrus.Infof("Pulling layer %s (%d B)", layer.Digest, layer.Size)
+
+		start := time.Now()
+		if err := fs.applyLayer(ctx, i, layer); err != nil {
+			return nil, errors.Wrapf(err, "applying layer %s", layer.Digest)
+		}
+		fs.durations = append(fs.durations, time.Since(start))
+	}
+
+	return fs, nil
+}
+
+func (fs *FS) applyLayer(ctx context.Context, i int, layer registry.Layer) error {
+	blob, err := fs.image.Layer(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	return fs.index.Apply(i, blob, layer.MediaType)
+}
+
+func (fs *FS) Image() source.Image {
+	return fs.image
+}
+
+// Layers returns the applied layers, entries reference them by index
+func (fs *FS) Layers() []registry.Layer {
+	return fs.layers
+}
+
+// Durations returns how long pulling and indexing each layer took
+func (fs *FS) Durations() []time.Duration {
+	return fs.durations
+}
+
+func (fs *FS) Index() *dianatar.Index {
+	return fs.index
+}
+
+// Resolve returns the entry of the path following all symlinks
+func (fs *FS) Resolve(path string) (*dianatar.Entry, error) {
+	return fs.index.Resolve(path)
+}
+
+// Open returns the content of a regular file or hardlink entry, the caller has to close it
+func (fs *FS) Open(ctx context.Context, entry *dianatar.Entry) (io.ReadCloser, error) {
+	if !entry.HasContent() {
+		return nil, errors.Errorf("%s is not a regular file", entry.Path)
+	}
+
+	return openContent(ctx, fs.image, fs.layers[entry.ContentLayer], entry.ContentPath)
+}
+
+// openContent streams the content of the file at the path of the layer
+func openContent(ctx context.Context, image source.Image, layer registry.Layer, contentPath string) (io.ReadCloser, error) {
+	blob, err := image.Layer(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := dianatar.ForEach(blob, layer.MediaType, func(header *tar.Header, content io.Reader) error {
+			if dianatar.CleanPath(header.Name) != contentPath {
+				return nil
+			}
+			if _, err := io.Copy(pw, content); err != nil {
+				return err
+			}
+			return errFound
+		})
+		switch err {
+		case errFound:
+			//the layer is only verified once it's read to EOF, a mismatch fails the last read
+			_, err = io.Copy(ioutil.Discard, blob)
+		case nil:
+			err = errors.Errorf("%s not found in layer %s", contentPath, layer.Digest)
+		}
+		if closeErr := blob.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// ReadFile returns the content of the file at path following all symlinks
+func (fs *FS) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	entry, err := fs.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := fs.Open(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,111 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+)
+
+// contentRef identifies the content of a file within a layer
+type contentRef struct {
+	layer int
+	path  string
+}
+
+// contentOwners picks one entry for every file content, preferring the entry at the path the
+// content was added with. All other entries sharing the content (hardlinks) are returned as links.
+func (fs *FS) contentOwners() (map[contentRef]*dianatar.Entry, []*dianatar.Entry) {
+	owners := map[contentRef]*dianatar.Entry{}
+	var links []*dianatar.Entry
+
+	for _, e := range fs.index.Entries() {
+		if !e.HasContent() {
+			continue
+		}
+
+		ref := contentRef{e.ContentLayer, e.ContentPath}
+		owner, ok := owners[ref]
+		switch {
+		case !ok:
+			owners[ref] = e
+		case e.Path == e.ContentPath && owner.Path != owner.ContentPath:
+			links = append(links, owner)
+			owners[ref] = e
+		default:
+			links = append(links, e)
+		}
+	}
+
+	return owners, links
+}
+
+// WalkFiles calls fn with the content of every regular file matching the filter, reading each
+// layer once at most. Files sharing their content (hardlinks) are only visited once.
+func (fs *FS) WalkFiles(ctx context.Context, match func(e *dianatar.Entry) bool, fn func(e *dianatar.Entry, content io.Reader) error) error {
+	owners, _ := fs.contentOwners()
+	return fs.walkOwners(ctx, owners, match, fn)
+}
+
+func (fs *FS) walkOwners(ctx context.Context, owners map[contentRef]*dianatar.Entry, match func(e *dianatar.Entry) bool, fn func(e *dianatar.Entry, content io.Reader) error) error {
+	pending := make([]map[string]*dianatar.Entry, len(fs.layers))
+	for ref, e := range owners {
+		if match != nil && !match(e) {
+			continue
+		}
+		if pending[ref.layer] == nil {
+			pending[ref.layer] = map[string]*dianatar.Entry{}
+		}
+		pending[ref.layer][ref.path] = e
+	}
+
+	for i, layer := range fs.layers {
+		if len(pending[i]) == 0 {
+			continue
+		}
+		if err := fs.walkLayer(ctx, i, pending[i], fn); err != nil {
+			return errors.Wrapf(err, "reading layer %s", layer.Digest)
+		}
+	}
+	return nil
+}
+
+func (fs *FS) walkLayer(ctx context.Context, i int, pending map[string]*dianatar.Entry, fn func(e *dianatar.Entry, content io.Reader) error) error {
+	layer := fs.layers[i]
+	blob, err := fs.image.Layer(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	//the same path may occur multiple times within a layer, the index holds the last one
+	skip := map[string]int{}
+	for p := range pending {
+		skip[p] = fs.index.Occurrences(p, i) - 1
+	}
+
+	err = dianatar.ForEach(blob, layer.MediaType, func(header *tar.Header, content io.Reader) error {
+		p := dianatar.CleanPath(header.Name)
+		e, ok := pending[p]
+		if !ok {
+			return nil
+		}
+		if skip[p] > 0 {
+			skip[p]--
+			return nil
+		}
+		delete(pending, p)
+
+		return fn(e, content)
+	})
+	if err != nil {
+		return err
+	}
+
+	for p := range pending {
+		return errors.Errorf("%s not found in layer", p)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const apkInstalledPath = "/lib/apk/db/installed"
+
+// parseAPKInstalled reads the installed packages of the apk database
+func parseAPKInstalled(data []byte, location string) []Package {
+	var packages []Package
+	var current Package
+
+	flush := func() {
+		if current.Name != "" {
+			current.Type = TypeAPK
+			current.Location = location
+			packages = append(packages, current)
+		}
+		current = Package{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		value := line[2:]
+		switch line[0] {
+		case 'P':
+			current.Name = value
+		case 'V':
+			current.Version = value
+		case 'A':
+			current.Arch = value
+		case 'L':
+			current.License = value
+		}
+	}
+	flush()
+
+	return packages
+}
+
+func apkPURL(p Package, distro string) string {
+	purl := fmt.Sprintf("pkg:apk/%s/%s@%s", purlEscape(distro), purlEscape(p.Name), purlEscape(p.Version))
+	return purl + qualifiers("arch", p.Arch)
+}
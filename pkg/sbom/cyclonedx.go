@@ -0,0 +1,98 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type cycloneDXBOM struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string               `json:"timestamp"`
+	Tools     []cycloneDXComponent `json:"tools"`
+	Component cycloneDXComponent   `json:"component"`
+}
+
+type cycloneDXComponent struct {
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Type       string              `json:"type,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// WriteCycloneDX writes the packages of the image as CycloneDX 1.5 JSON
+func WriteCycloneDX(w io.Writer, image string, result *Result) error {
+	bom := cycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     []cycloneDXComponent{{Name: "diana"}},
+			Component: cycloneDXComponent{
+				BOMRef: "image",
+				Type:   "container",
+				Name:   image,
+			},
+		},
+		Components: []cycloneDXComponent{},
+	}
+
+	for i, p := range result.Packages {
+		component := cycloneDXComponent{
+			BOMRef:  fmt.Sprintf("%s-%d", p.Type, i+1),
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL,
+			Properties: []cycloneDXProperty{
+				{Name: "diana:package:type", Value: p.Type},
+				{Name: "diana:location", Value: p.Location},
+			},
+		}
+		if p.License != "" {
+			var license cycloneDXLicense
+			license.License.Name = p.License
+			component.Licenses = []cycloneDXLicense{license}
+		}
+		bom.Components = append(bom.Components, component)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bom)
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
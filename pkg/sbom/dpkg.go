@@ -0,0 +1,71 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	dpkgStatusPath = "/var/lib/dpkg/status"
+	//distroless images ship one status file per package
+	dpkgStatusDir = "/var/lib/dpkg/status.d"
+)
+
+// parseDpkgStatus reads the installed packages of a dpkg status file
+func parseDpkgStatus(data []byte, location string) []Package {
+	var packages []Package
+	for _, fields := range parseControl(data) {
+		if fields["Package"] == "" {
+			continue
+		}
+		//packages which were removed, but whose config files are left, are listed too
+		if status := strings.Fields(fields["Status"]); len(status) == 3 && status[2] != "installed" {
+			continue
+		}
+
+		packages = append(packages, Package{
+			Type:     TypeDeb,
+			Name:     fields["Package"],
+			Version:  fields["Version"],
+			Arch:     fields["Architecture"],
+			Location: location,
+		})
+	}
+	return packages
+}
+
+// parseControl splits a file in Debian control format into its paragraphs of fields,
+// continuation lines are dropped
+func parseControl(data []byte) []map[string]string {
+	var paragraphs []map[string]string
+	fields := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(fields) > 0 {
+				paragraphs = append(paragraphs, fields)
+				fields = map[string]string{}
+			}
+		case line[0] == ' ' || line[0] == '\t':
+		default:
+			if i := strings.Index(line, ":"); i > 0 {
+				fields[line[:i]] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	if len(fields) > 0 {
+		paragraphs = append(paragraphs, fields)
+	}
+	return paragraphs
+}
+
+func debPURL(p Package, distro string) string {
+	purl := fmt.Sprintf("pkg:deb/%s/%s@%s", purlEscape(distro), purlEscape(p.Name), purlEscape(p.Version))
+	return purl + qualifiers("arch", p.Arch)
+}
@@ -0,0 +1,83 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var executableMagics = [][]byte{
+	[]byte("\x7fELF"),
+	[]byte("MZ"),
+	{0xfe, 0xed, 0xfa, 0xce},
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xcf, 0xfa, 0xed, 0xfe},
+}
+
+// parseGoBinary returns the main module and dependencies embedded into a Go binary, nothing is
+// returned for other files
+func parseGoBinary(content io.Reader, location string) []Package {
+	r := bufio.NewReader(content)
+	magic, _ := r.Peek(4)
+	if !isExecutable(magic) {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	info, err := buildinfo.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	var packages []Package
+	if info.Main.Path != "" {
+		packages = append(packages, Package{
+			Type:     TypeGo,
+			Name:     info.Main.Path,
+			Version:  info.Main.Version,
+			Location: location,
+		})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		packages = append(packages, Package{
+			Type:     TypeGo,
+			Name:     dep.Path,
+			Version:  dep.Version,
+			Location: location,
+		})
+	}
+	return packages
+}
+
+func isExecutable(magic []byte) bool {
+	for _, m := range executableMagics {
+		if bytes.HasPrefix(magic, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func goPURL(p Package) string {
+	segments := strings.Split(p.Name, "/")
+	for i, s := range segments {
+		segments[i] = purlEscape(s)
+	}
+
+	purl := "pkg:golang/" + strings.Join(segments, "/")
+	if p.Version != "" && p.Version != "(devel)" {
+		purl += fmt.Sprintf("@%s", purlEscape(p.Version))
+	}
+	return purl
+}
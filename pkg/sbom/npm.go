@@ -0,0 +1,59 @@
+package sbom
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+type packageJSON struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	License json.RawMessage `json:"license"`
+}
+
+// isNodeModule reports whether the file is the package.json of an installed node module
+func isNodeModule(p string) bool {
+	if path.Base(p) != "package.json" {
+		return false
+	}
+
+	dir := path.Dir(path.Dir(p))
+	if strings.HasPrefix(path.Base(dir), "@") {
+		dir = path.Dir(dir)
+	}
+	return path.Base(dir) == "node_modules"
+}
+
+func parsePackageJSON(data []byte, location string) []Package {
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil || pkg.Name == "" {
+		return nil
+	}
+
+	//the license is either an SPDX expression or a legacy {"type": ...} object
+	var license string
+	if json.Unmarshal(pkg.License, &license) != nil {
+		var legacy struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(pkg.License, &legacy)
+		license = legacy.Type
+	}
+
+	return []Package{{
+		Type:     TypeNPM,
+		Name:     pkg.Name,
+		Version:  pkg.Version,
+		License:  license,
+		Location: location,
+	}}
+}
+
+func npmPURL(p Package) string {
+	name := purlEscape(p.Name)
+	if i := strings.Index(p.Name, "/"); strings.HasPrefix(p.Name, "@") && i > 0 {
+		name = "%40" + purlEscape(p.Name[1:i]) + "/" + purlEscape(p.Name[i+1:])
+	}
+	return "pkg:npm/" + name + "@" + purlEscape(p.Version)
+}
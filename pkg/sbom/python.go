@@ -0,0 +1,55 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var pypiNormalize = regexp.MustCompile(`[-_.]+`)
+
+// isDistInfo reports whether the file holds the metadata of an installed Python package
+func isDistInfo(p string) bool {
+	return path.Base(p) == "METADATA" && strings.HasSuffix(path.Dir(p), ".dist-info")
+}
+
+// parseDistMetadata reads the core metadata fields, which are email style headers
+func parseDistMetadata(data []byte, location string) []Package {
+	pkg := Package{Type: TypePyPI, Location: location}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch line[:i] {
+		case "Name":
+			pkg.Name = value
+		case "Version":
+			pkg.Version = value
+		case "License":
+			pkg.License = value
+		case "License-Expression":
+			pkg.License = value
+		}
+	}
+
+	if pkg.Name == "" {
+		return nil
+	}
+	return []Package{pkg}
+}
+
+func pypiPURL(p Package) string {
+	name := strings.ToLower(pypiNormalize.ReplaceAllString(p.Name, "-"))
+	return "pkg:pypi/" + purlEscape(name) + "@" + purlEscape(p.Version)
+}
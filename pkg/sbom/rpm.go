@@ -0,0 +1,129 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const rpmDBPath = "/var/lib/rpm/rpmdb.sqlite"
+
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagLicense = 1014
+	rpmTagArch    = 1022
+
+	rpmTypeInt32        = 4
+	rpmTypeString       = 6
+	rpmTypeI18NString   = 9
+	rpmIndexEntrySize   = 16
+	rpmMaxHeaderEntries = 0xffff
+)
+
+// parseRPMDB reads the installed packages of the rpm sqlite database (rpm >= 4.16)
+func parseRPMDB(data []byte, location string) ([]Package, error) {
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.table("Packages")
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		blob, ok := row[1].([]byte)
+		if !ok {
+			continue
+		}
+
+		tags, err := parseRPMHeader(blob)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing package header")
+		}
+
+		version := tags[rpmTagVersion]
+		if release := tags[rpmTagRelease]; release != "" {
+			version += "-" + release
+		}
+		if epoch := tags[rpmTagEpoch]; epoch != "" && epoch != "0" {
+			version = epoch + ":" + version
+		}
+
+		packages = append(packages, Package{
+			Type:     TypeRPM,
+			Name:     tags[rpmTagName],
+			Version:  version,
+			Arch:     tags[rpmTagArch],
+			License:  tags[rpmTagLicense],
+			Location: location,
+		})
+	}
+	return packages, nil
+}
+
+// parseRPMHeader returns the string and integer tags of a header blob as stored in the
+// database, which is the header without its magic
+func parseRPMHeader(blob []byte) (map[int]string, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("header too short")
+	}
+	entries := int(binary.BigEndian.Uint32(blob))
+	dataSize := int(binary.BigEndian.Uint32(blob[4:]))
+	if entries > rpmMaxHeaderEntries || 8+entries*rpmIndexEntrySize+dataSize > len(blob) {
+		return nil, errors.New("invalid header size")
+	}
+
+	store := blob[8+entries*rpmIndexEntrySize:][:dataSize]
+	tags := map[int]string{}
+	for i := 0; i < entries; i++ {
+		entry := blob[8+i*rpmIndexEntrySize:]
+		tag := int(binary.BigEndian.Uint32(entry))
+		kind := binary.BigEndian.Uint32(entry[4:])
+		offset := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset >= len(store) {
+			continue
+		}
+
+		switch kind {
+		case rpmTypeString, rpmTypeI18NString:
+			value := store[offset:]
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			tags[tag] = string(value)
+		case rpmTypeInt32:
+			if offset+4 <= len(store) {
+				tags[tag] = strconv.FormatUint(uint64(binary.BigEndian.Uint32(store[offset:])), 10)
+			}
+		}
+	}
+
+	if tags[rpmTagName] == "" {
+		return nil, errors.New("header without package name")
+	}
+	return tags, nil
+}
+
+// rpmPURL moves the epoch of the version into a qualifier as expected by the purl spec
+func rpmPURL(p Package, distro string) string {
+	version, epoch := p.Version, ""
+	if i := strings.Index(version, ":"); i >= 0 {
+		epoch, version = version[:i], version[i+1:]
+	}
+
+	purl := fmt.Sprintf("pkg:rpm/%s/%s@%s", purlEscape(distro), purlEscape(p.Name), purlEscape(version))
+	return purl + qualifiers("arch", p.Arch, "epoch", epoch)
+}
@@ -0,0 +1,191 @@
+package sbom
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/rootfs"
+	"github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	TypeDeb  = "deb"
+	TypeAPK  = "apk"
+	TypeRPM  = "rpm"
+	TypeGo   = "golang"
+	TypeNPM  = "npm"
+	TypePyPI = "pypi"
+)
+
+const osReleasePath = "/etc/os-release"
+
+// Package is a software package found in an image
+type Package struct {
+	Type    string
+	Name    string
+	Version string
+	Arch    string
+	License string
+	// Location is the path of the file the package was found in
+	Location string
+	PURL     string
+}
+
+// Distro identifies the distribution of an image from its os-release file
+type Distro struct {
+	ID        string
+	VersionID string
+	Name      string
+}
+
+// Result holds all packages of an image
+type Result struct {
+	Distro   Distro
+	Packages []Package
+}
+
+// Scan reads the package databases, Go binaries and language package manifests of the merged
+// filesystem. Every layer is read once at most.
+func Scan(ctx context.Context, fs *rootfs.FS) (*Result, error) {
+	result := &Result{}
+
+	//package databases are found by their resolved path as they might be symlinked
+	databases := map[string]string{}
+	for _, p := range []string{dpkgStatusPath, apkInstalledPath, rpmDBPath, osReleasePath} {
+		if e, err := fs.Resolve(p); err == nil && e.HasContent() {
+			databases[e.Path] = p
+		}
+	}
+	statusDir := ""
+	if e, err := fs.Resolve(dpkgStatusDir); err == nil && e.IsDir() {
+		statusDir = e.Path
+	}
+
+	match := func(e *tar.Entry) bool {
+		_, ok := databases[e.Path]
+		return ok || path.Dir(e.Path) == statusDir || isNodeModule(e.Path) || isDistInfo(e.Path) ||
+			e.Header.Mode&0111 != 0
+	}
+
+	err := fs.WalkFiles(ctx, match, func(e *tar.Entry, content io.Reader) error {
+		db, isDB := databases[e.Path]
+		if !isDB && !isNodeModule(e.Path) && !isDistInfo(e.Path) && path.Dir(e.Path) != statusDir {
+			result.Packages = append(result.Packages, parseGoBinary(content, e.Path)...)
+			return nil
+		}
+
+		data, err := ioutil.ReadAll(content)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case db == osReleasePath:
+			result.Distro = parseOSRelease(data)
+		case db == dpkgStatusPath, path.Dir(e.Path) == statusDir:
+			result.Packages = append(result.Packages, parseDpkgStatus(data, e.Path)...)
+		case db == apkInstalledPath:
+			result.Packages = append(result.Packages, parseAPKInstalled(data, e.Path)...)
+		case db == rpmDBPath:
+			packages, err := parseRPMDB(data, e.Path)
+			if err != nil {
+				logrus.WithError(err).Warnf("Failed to read rpm database %s", e.Path)
+			}
+			result.Packages = append(result.Packages, packages...)
+		case isNodeModule(e.Path):
+			result.Packages = append(result.Packages, parsePackageJSON(data, e.Path)...)
+		case isDistInfo(e.Path):
+			result.Packages = append(result.Packages, parseDistMetadata(data, e.Path)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "scanning image")
+	}
+
+	for i := range result.Packages {
+		result.Packages[i].PURL = purl(result.Packages[i], result.Distro)
+	}
+	sort.SliceStable(result.Packages, func(i, j int) bool {
+		a, b := result.Packages[i], result.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Location < b.Location
+	})
+
+	return result, nil
+}
+
+func parseOSRelease(data []byte) Distro {
+	var d Distro
+	for _, line := range strings.Split(string(data), "\n") {
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+		switch strings.TrimSpace(line[:i]) {
+		case "ID":
+			d.ID = value
+		case "VERSION_ID":
+			d.VersionID = value
+		case "PRETTY_NAME":
+			d.Name = value
+		}
+	}
+	return d
+}
+
+func purl(p Package, distro Distro) string {
+	switch p.Type {
+	case TypeDeb:
+		return debPURL(p, distroOr(distro, "debian"))
+	case TypeAPK:
+		return apkPURL(p, distroOr(distro, "alpine"))
+	case TypeRPM:
+		return rpmPURL(p, distroOr(distro, "redhat"))
+	case TypeGo:
+		return goPURL(p)
+	case TypeNPM:
+		return npmPURL(p)
+	case TypePyPI:
+		return pypiPURL(p)
+	}
+	return ""
+}
+
+func distroOr(d Distro, fallback string) string {
+	if d.ID != "" {
+		return d.ID
+	}
+	return fallback
+}
+
+func purlEscape(s string) string {
+	return url.PathEscape(s)
+}
+
+// qualifiers formats the non-empty key value pairs as purl qualifiers
+func qualifiers(pairs ...string) string {
+	var q []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			q = append(q, pairs[i]+"="+url.QueryEscape(pairs[i+1]))
+		}
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + strings.Join(q, "&")
+}
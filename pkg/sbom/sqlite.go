@@ -0,0 +1,293 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// sqliteDB is a minimal read-only reader of SQLite database files, just enough to read all rows
+// of a table without depending on a SQLite driver
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+const (
+	sqliteMagic = "SQLite format 3\x00"
+
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+
+	//bound on the pages visited per table, guards against cycles in corrupt files
+	maxPages = 1 << 20
+)
+
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < 100 || !bytes.HasPrefix(data, []byte(sqliteMagic)) {
+		return nil, errors.New("not a SQLite database")
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 {
+		return nil, errors.Errorf("invalid SQLite page size %d", pageSize)
+	}
+
+	return &sqliteDB{
+		data:     data,
+		pageSize: pageSize,
+		usable:   pageSize - int(data[20]),
+	}, nil
+}
+
+// table returns all rows of the table as their column values
+func (db *sqliteDB) table(name string) ([][]interface{}, error) {
+	schema, err := db.rows(1)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading schema")
+	}
+
+	for _, row := range schema {
+		if len(row) < 4 || row[0] != "table" || row[1] != name {
+			continue
+		}
+		root, ok := row[3].(int64)
+		if !ok {
+			return nil, errors.Errorf("invalid root page of table %s", name)
+		}
+		return db.rows(int(root))
+	}
+
+	return nil, errors.Errorf("table %s not found", name)
+}
+
+// rows walks the table b-tree starting at the root page
+func (db *sqliteDB) rows(root int) ([][]interface{}, error) {
+	var rows [][]interface{}
+	pages := []int{root}
+	visited := 0
+
+	for len(pages) > 0 {
+		page := pages[len(pages)-1]
+		pages = pages[:len(pages)-1]
+		if visited++; visited > maxPages {
+			return nil, errors.New("too many pages")
+		}
+
+		data, offset, err := db.page(page)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < offset+8 {
+			return nil, errors.Errorf("page %d is truncated", page)
+		}
+
+		kind := data[offset]
+		cells := int(binary.BigEndian.Uint16(data[offset+3:]))
+		headerSize := 8
+		if kind == pageInteriorTable {
+			headerSize = 12
+			pages = append(pages, int(binary.BigEndian.Uint32(data[offset+8:])))
+		} else if kind != pageLeafTable {
+			return nil, errors.Errorf("page %d is not a table page", page)
+		}
+
+		for c := cells - 1; c >= 0; c-- {
+			ptr := offset + headerSize + 2*c
+			if len(data) < ptr+2 {
+				return nil, errors.Errorf("page %d is truncated", page)
+			}
+			cell := int(binary.BigEndian.Uint16(data[ptr:]))
+			if cell >= len(data) {
+				return nil, errors.Errorf("invalid cell in page %d", page)
+			}
+
+			if kind == pageInteriorTable {
+				if len(data) < cell+4 {
+					return nil, errors.Errorf("invalid cell in page %d", page)
+				}
+				pages = append(pages, int(binary.BigEndian.Uint32(data[cell:])))
+				continue
+			}
+
+			payload, err := db.payload(data[cell:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading cell of page %d", page)
+			}
+			row, err := decodeRecord(payload)
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding cell of page %d", page)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	//pages are visited last to first, restore the order of the table
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	return rows, nil
+}
+
+// page returns the page and the offset of its b-tree header, which is behind the file header
+// on the first page
+func (db *sqliteDB) page(n int) ([]byte, int, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, 0, errors.Errorf("page %d out of range", n)
+	}
+
+	offset := 0
+	if n == 1 {
+		offset = 100
+	}
+	return db.data[start : start+db.pageSize], offset, nil
+}
+
+// payload reads the payload of a table leaf cell including its overflow pages
+func (db *sqliteDB) payload(cell []byte) ([]byte, error) {
+	size, n := readVarint(cell)
+	if n == 0 {
+		return nil, errors.New("invalid payload size")
+	}
+	_, m := readVarint(cell[n:]) //rowid
+	if m == 0 {
+		return nil, errors.New("invalid rowid")
+	}
+	cell = cell[n+m:]
+
+	local := db.localPayload(int(size))
+	if local > len(cell) {
+		return nil, errors.New("payload exceeds page")
+	}
+	payload := append([]byte{}, cell[:local]...)
+	if local == int(size) {
+		return payload, nil
+	}
+
+	if len(cell) < local+4 {
+		return nil, errors.New("missing overflow page")
+	}
+	next := int(binary.BigEndian.Uint32(cell[local:]))
+	for visited := 0; len(payload) < int(size); visited++ {
+		if next == 0 || visited > maxPages {
+			return nil, errors.New("truncated overflow chain")
+		}
+		page, _, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+
+		content := page[4:db.usable]
+		if remaining := int(size) - len(payload); remaining < len(content) {
+			content = content[:remaining]
+		}
+		payload = append(payload, content...)
+		next = int(binary.BigEndian.Uint32(page))
+	}
+	return payload, nil
+}
+
+// localPayload returns how many bytes of the payload are stored on the leaf page
+func (db *sqliteDB) localPayload(size int) int {
+	u := db.usable
+	x := u - 35
+	if size <= x {
+		return size
+	}
+
+	m := (u-12)*32/255 - 23
+	k := m + (size-m)%(u-4)
+	if k <= x {
+		return k
+	}
+	return m
+}
+
+func decodeRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := readVarint(payload)
+	if n == 0 || int(headerSize) > len(payload) {
+		return nil, errors.New("invalid record header")
+	}
+
+	var types []uint64
+	for pos := n; pos < int(headerSize); {
+		t, m := readVarint(payload[pos:])
+		if m == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		types = append(types, t)
+		pos += m
+	}
+
+	values := make([]interface{}, 0, len(types))
+	body := payload[headerSize:]
+	for _, t := range types {
+		size := serialSize(t)
+		if size > len(body) {
+			return nil, errors.New("record exceeds payload")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t <= 6:
+			var v int64
+			for _, b := range value {
+				v = v<<8 | int64(b)
+			}
+			//sign extend
+			shift := uint(64 - 8*size)
+			values = append(values, v<<shift>>shift)
+		case t == 7:
+			values = append(values, value)
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t >= 12 && t%2 == 0:
+			values = append(values, value)
+		case t >= 13:
+			values = append(values, string(value))
+		default:
+			return nil, errors.Errorf("invalid serial type %d", t)
+		}
+	}
+	return values, nil
+}
+
+func serialSize(t uint64) int {
+	switch {
+	case t <= 4:
+		return int(t)
+	case t == 5:
+		return 6
+	case t == 6, t == 7:
+		return 8
+	case t >= 12:
+		return int(t-12) / 2
+	}
+	return 0
+}
+
+// readVarint reads a SQLite varint, returning 0 bytes read if it's truncated
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
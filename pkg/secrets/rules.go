@@ -0,0 +1,181 @@
+package secrets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Rule flags files by their path, their content or both. Content rules with a minimum entropy
+// only report matches whose last capture group (or the whole match) is random enough.
+type Rule struct {
+	ID          string  `json:"id"`
+	Description string  `json:"description"`
+	Path        string  `json:"path,omitempty"`
+	Pattern     string  `json:"pattern,omitempty"`
+	MinEntropy  float64 `json:"minEntropy,omitempty"`
+	// Disabled removes a default rule with the same ID when loading custom rules
+	Disabled bool `json:"disabled,omitempty"`
+
+	path    *regexp.Regexp
+	pattern *regexp.Regexp
+}
+
+// DefaultRules are the built-in rules
+var DefaultRules = []Rule{
+	{
+		ID:          "private-key",
+		Description: "Private key",
+		Pattern:     `-----BEGIN (?:RSA |DSA |EC |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`,
+	},
+	{
+		ID:          "aws-access-key-id",
+		Description: "AWS access key ID",
+		Pattern:     `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	},
+	{
+		ID:          "aws-secret-access-key",
+		Description: "AWS secret access key",
+		Pattern:     `(?i)aws_?secret_?access_?key\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`,
+	},
+	{
+		ID:          "gcp-service-account",
+		Description: "Google Cloud service account key",
+		Pattern:     `"private_key_id"\s*:\s*"[0-9a-f]{40}"`,
+	},
+	{
+		ID:          "github-token",
+		Description: "GitHub token",
+		Pattern:     `\bgh[pousr]_[A-Za-z0-9]{36}\b`,
+	},
+	{
+		ID:          "slack-token",
+		Description: "Slack token",
+		Pattern:     `\bxox[abposr]-[0-9A-Za-z-]{10,}`,
+	},
+	{
+		ID:          "npmrc-token",
+		Description: "npm registry token",
+		Path:        `(?:^|/)\.npmrc$`,
+		Pattern:     `_(?:authToken|auth|password)\s*=\s*\S+`,
+	},
+	{
+		ID:          "pypirc-password",
+		Description: "PyPI credentials",
+		Path:        `(?:^|/)\.pypirc$`,
+		Pattern:     `(?m)^\s*password\s*[:=]\s*\S+`,
+	},
+	{
+		ID:          "docker-config-auth",
+		Description: "Docker registry credentials",
+		Path:        `(?:^|/)\.docker/config\.json$`,
+		Pattern:     `"auth"\s*:\s*"[^"]+"`,
+	},
+	{
+		ID:          "git-directory",
+		Description: "Git repository, its history may contain secrets",
+		Path:        `(?:^|/)\.git/config$`,
+	},
+	{
+		ID:          "high-entropy-assignment",
+		Description: "High entropy string assigned to a secret looking key",
+		Pattern:     `(?i)(?:secret|token|passw(?:or)?d|api_?key|credential)[\w.-]*["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_\-]{16,})`,
+		MinEntropy:  3.5,
+	},
+}
+
+// LoadRules merges the rules of the JSON file into the default rules, rules with the ID of a
+// default rule replace it
+func LoadRules(file string, defaults bool) ([]Rule, error) {
+	var rules []Rule
+	if defaults {
+		rules = append(rules, DefaultRules...)
+	}
+
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading rules file %s", file)
+		}
+
+		var custom []Rule
+		if err := json.Unmarshal(content, &custom); err != nil {
+			return nil, errors.Wrapf(err, "parsing rules file %s", file)
+		}
+		rules = mergeRules(rules, custom)
+	}
+
+	return compileRules(rules)
+}
+
+func mergeRules(rules, custom []Rule) []Rule {
+	for _, c := range custom {
+		replaced := false
+		for i, r := range rules {
+			if r.ID == c.ID {
+				rules[i] = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			rules = append(rules, c)
+		}
+	}
+
+	enabled := rules[:0]
+	for _, r := range rules {
+		if !r.Disabled {
+			enabled = append(enabled, r)
+		}
+	}
+	return enabled
+}
+
+func compileRules(rules []Rule) ([]Rule, error) {
+	compiled := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		if r.ID == "" {
+			return nil, errors.New("rule without id")
+		}
+		if r.Path == "" && r.Pattern == "" {
+			return nil, errors.Errorf("rule %s needs a path or a pattern", r.ID)
+		}
+
+		var err error
+		if r.Path != "" {
+			if r.path, err = regexp.Compile(r.Path); err != nil {
+				return nil, errors.Wrapf(err, "compiling path of rule %s", r.ID)
+			}
+		}
+		if r.Pattern != "" {
+			if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+				return nil, errors.Wrapf(err, "compiling pattern of rule %s", r.ID)
+			}
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// entropy returns the Shannon entropy of the string in bits per character
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+
+	var e float64
+	n := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := float64(count) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
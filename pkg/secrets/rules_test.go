@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		s       string
+		entropy float64
+	}{
+		{s: "", entropy: 0},
+		{s: "aaaaaaaa", entropy: 0},
+		{s: "abababab", entropy: 1},
+		{s: "abcdabcd", entropy: 2},
+		{s: "0123456789abcdef", entropy: 4},
+	}
+	for _, tt := range tests {
+		if e := entropy(tt.s); math.Abs(e-tt.entropy) > 1e-9 {
+			t.Errorf("%q: expected %f, got %f", tt.s, tt.entropy, e)
+		}
+	}
+}
+
+func writeRules(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func ruleIDs(rules []Rule) map[string]Rule {
+	ids := map[string]Rule{}
+	for _, r := range rules {
+		ids[r.ID] = r
+	}
+	return ids
+}
+
+func TestLoadRules(t *testing.T) {
+	file := writeRules(t, `[
+		{"id": "github-token", "description": "Custom GitHub token", "pattern": "ghp_[0-9a-zA-Z]+"},
+		{"id": "slack-token", "disabled": true},
+		{"id": "env-file", "description": "Environment file", "path": "(?:^|/)\\.env$"}
+	]`)
+
+	rules, err := LoadRules(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ruleIDs(rules)
+	if len(rules) != len(DefaultRules) {
+		t.Errorf("expected one rule to be replaced, one removed and one added, got %d rules", len(rules))
+	}
+	if r, ok := ids["github-token"]; !ok || r.Description != "Custom GitHub token" {
+		t.Errorf("expected the default rule to be replaced, got %v", r)
+	}
+	if _, ok := ids["slack-token"]; ok {
+		t.Error("expected the disabled rule to be removed")
+	}
+	if r, ok := ids["env-file"]; !ok || r.path == nil || r.pattern != nil {
+		t.Errorf("expected the path rule to be added, got %v", r)
+	}
+	if DefaultRules[4].ID != "github-token" || DefaultRules[4].Description != "GitHub token" {
+		t.Error("expected the default rules not to be modified")
+	}
+
+	rules, err = LoadRules(file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := ruleIDs(rules); len(ids) != 2 || ids["github-token"].ID == "" || ids["env-file"].ID == "" {
+		t.Errorf("expected only the custom rules, got %v", rules)
+	}
+}
+
+func TestLoadRulesRejectsInvalidRules(t *testing.T) {
+	for name, content := range map[string]string{
+		"invalid json":    `{`,
+		"missing id":      `[{"pattern": "secret"}]`,
+		"no path/pattern": `[{"id": "empty"}]`,
+		"invalid pattern": `[{"id": "broken", "pattern": "("}]`,
+		"invalid path":    `[{"id": "broken", "path": "["}]`,
+	} {
+		if _, err := LoadRules(writeRules(t, content), true); err == nil {
+			t.Errorf("%s: expected the rules to be rejected", name)
+		}
+	}
+
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.json"), true); err == nil {
+		t.Error("expected a missing rules file to fail")
+	}
+}
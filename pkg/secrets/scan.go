@@ -0,0 +1,166 @@
+package secrets
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	dianatar "github.com/cedrickring/diana/pkg/tar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxFileSize is the size up to which file contents are scanned
+	MaxFileSize = 10 << 20
+
+	//files with a NUL byte within the first bytes are considered binary and only matched by path
+	binarySniffSize = 8000
+)
+
+// Finding is a secret found in a file of a layer
+type Finding struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+	Layer       int    `json:"layer"`
+	LayerDigest string `json:"layerDigest"`
+	// CreatedBy is the instruction which created the layer, if the image has a history
+	CreatedBy string `json:"createdBy,omitempty"`
+	Path      string `json:"path"`
+	Line      int    `json:"line,omitempty"`
+	// Match is the redacted match
+	Match string `json:"match,omitempty"`
+	// Deleted is set if the file was removed or replaced by a later layer, it's still
+	// recoverable from the layer though
+	Deleted bool `json:"deleted"`
+}
+
+// Scan streams every layer of the image once and matches all files against the rules,
+// including the ones removed by later layers
+func Scan(ctx context.Context, img source.Image, rules []Rule) ([]Finding, error) {
+	layers := img.Manifest().Layers
+	history := make([]registry.History, len(layers))
+	if raw, err := img.Config(ctx); err == nil {
+		config, err := registry.ParseImageConfig(raw)
+		if err != nil {
+			return nil, err
+		}
+		history = config.LayerHistory(len(layers))
+	} else if err != source.ErrNoConfig {
+		return nil, err
+	}
+
+	index := dianatar.NewIndex()
+	var findings []Finding
+
+	for i, layer := range layers {
+		logrus.Infof("Scanning layer %s (%d B)", layer.Digest, layer.Size)
+
+		blob, err := img.Layer(ctx, layer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening layer %s", layer.Digest)
+		}
+
+		err = dianatar.ForEach(blob, layer.MediaType, func(header *tar.Header, content io.Reader) error {
+			index.Add(i, header)
+			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+				return nil
+			}
+
+			matches, err := scanFile(dianatar.CleanPath(header.Name), header.Size, content, rules)
+			for _, f := range matches {
+				f.Layer = i
+				f.LayerDigest = layer.Digest
+				f.CreatedBy = history[i].CreatedBy
+				findings = append(findings, f)
+			}
+			return err
+		})
+		blob.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "scanning layer %s", layer.Digest)
+		}
+	}
+
+	for n, f := range findings {
+		e, ok := index.Lookup(f.Path)
+		findings[n].Deleted = !ok || e.ContentLayer != f.Layer || e.ContentPath != f.Path
+	}
+	return findings, nil
+}
+
+func scanFile(p string, size int64, content io.Reader, rules []Rule) ([]Finding, error) {
+	var findings []Finding
+	var data []byte
+	read := false
+
+	for _, r := range rules {
+		if r.path != nil && !r.path.MatchString(p) {
+			continue
+		}
+		if r.pattern == nil {
+			findings = append(findings, Finding{Rule: r.ID, Description: r.Description, Path: p})
+			continue
+		}
+
+		if !read {
+			read = true
+			if size > MaxFileSize {
+				logrus.Debugf("Skipping content of %s, it's larger than %d B", p, MaxFileSize)
+				continue
+			}
+
+			var err error
+			if data, err = ioutil.ReadAll(content); err != nil {
+				return nil, err
+			}
+			if bytes.IndexByte(data[:min(len(data), binarySniffSize)], 0) >= 0 {
+				data = nil
+			}
+		}
+		if data == nil {
+			continue
+		}
+
+		for _, loc := range r.pattern.FindAllSubmatchIndex(data, -1) {
+			secret := data[loc[0]:loc[1]]
+			if len(loc) > 2 && loc[len(loc)-2] >= 0 {
+				secret = data[loc[len(loc)-2]:loc[len(loc)-1]]
+			}
+			if r.MinEntropy > 0 && entropy(string(secret)) < r.MinEntropy {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				Rule:        r.ID,
+				Description: r.Description,
+				Path:        p,
+				Line:        bytes.Count(data[:loc[0]], []byte("\n")) + 1,
+				Match:       redact(string(data[loc[0]:loc[1]])),
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// redact keeps a few characters of the match to recognize it without leaking the secret
+func redact(match string) string {
+	match = strings.Split(match, "\n")[0]
+	if len(match) <= 8 {
+		return strings.Repeat("*", len(match))
+	}
+	return match[:8] + strings.Repeat("*", min(len(match)-8, 16))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
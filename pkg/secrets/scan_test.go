@@ -0,0 +1,164 @@
+package secrets
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/cedrickring/diana/pkg/source"
+	"github.com/pkg/errors"
+)
+
+// testImage serves uncompressed layers built from path to content maps, it has no config
+type testImage struct {
+	manifest *registry.Manifest
+	blobs    map[string][]byte
+}
+
+func newTestImage(t *testing.T, layers ...map[string]string) *testImage {
+	img := &testImage{manifest: &registry.Manifest{}, blobs: map[string][]byte{}}
+	for _, files := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte(content))
+		}
+		tw.Close()
+
+		digest := registry.Digest(buf.Bytes())
+		img.blobs[digest] = buf.Bytes()
+		img.manifest.Layers = append(img.manifest.Layers, registry.Layer{MediaType: registry.MediaTypeOCILayer, Digest: digest, Size: int64(buf.Len())})
+	}
+	return img
+}
+
+func (i *testImage) Reference() source.Reference {
+	return source.Reference{Transport: source.TransportOCI, Name: "test"}
+}
+
+func (i *testImage) Manifest() *registry.Manifest {
+	return i.manifest
+}
+
+func (i *testImage) Config(context.Context) ([]byte, error) {
+	return nil, source.ErrNoConfig
+}
+
+func (i *testImage) Layer(_ context.Context, layer registry.Layer) (io.ReadCloser, error) {
+	blob, ok := i.blobs[layer.Digest]
+	if !ok {
+		return nil, errors.Errorf("layer %s not found", layer.Digest)
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func (i *testImage) Close() error {
+	return nil
+}
+
+func defaultRules(t *testing.T) []Rule {
+	rules, err := LoadRules("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestScanFile(t *testing.T) {
+	//secrets are assembled at runtime to keep scanners of this repository quiet
+	githubToken := "ghp_" + strings.Repeat("a1B2", 9)
+	privateKey := "-----BEGIN " + "RSA PRIVATE KEY-----"
+
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		rules   []string
+		line    int
+	}{
+		{name: "private key", path: "/root/.ssh/id_rsa", content: privateKey + "\nMIIEow\n", rules: []string{"private-key"}, line: 1},
+		{name: "github token", path: "/app/config.yml", content: "log: debug\ngithub:\n  token: " + githubToken + "\n", rules: []string{"github-token"}, line: 3},
+		{name: "aws access key id", path: "/root/.aws/credentials", content: "[default]\naws_access_key_id = AKIA" + "IOSFODNN7EXAMPLE\n", rules: []string{"aws-access-key-id"}, line: 2},
+		{name: "npmrc", path: "/home/node/.npmrc", content: "//registry.npmjs.org/:_authToken=abc\n", rules: []string{"npmrc-token"}, line: 1},
+		{name: "npmrc pattern outside of npmrc", path: "/app/README", content: "_authToken=abc\n"},
+		{name: "git directory", path: "/app/.git/config", content: "[core]\n", rules: []string{"git-directory"}},
+		{name: "random assignment", path: "/app/.env", content: "API_KEY=Zx8Qp2Lm9Rt4Vb7Nc1Kd\n", rules: []string{"high-entropy-assignment"}, line: 1},
+		{name: "placeholder assignment", path: "/app/.env", content: "password=xxxxxxxxxxxxxxxxxxxx\n"},
+		{name: "binary file", path: "/usr/bin/app", content: "\x00\x01" + privateKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := scanFile(tt.path, int64(len(tt.content)), strings.NewReader(tt.content), defaultRules(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rules []string
+			for _, f := range findings {
+				rules = append(rules, f.Rule)
+				if f.Line != tt.line {
+					t.Errorf("expected line %d, got %d", tt.line, f.Line)
+				}
+				if strings.Contains(f.Match, githubToken) || strings.Contains(f.Match, "Zx8Qp2Lm9Rt4Vb7Nc1Kd") {
+					t.Errorf("expected the match to be redacted, got %s", f.Match)
+				}
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+				t.Errorf("expected %v, got %v", tt.rules, rules)
+			}
+		})
+	}
+}
+
+func TestScanFileSkipsLargeContent(t *testing.T) {
+	rules := defaultRules(t)
+	content := "-----BEGIN " + "PRIVATE KEY-----"
+
+	findings, err := scanFile("/app/.git/config", MaxFileSize+1, strings.NewReader(content), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//path rules still apply
+	if len(findings) != 1 || findings[0].Rule != "git-directory" {
+		t.Errorf("expected only the path rule to match, got %v", findings)
+	}
+}
+
+func TestScan(t *testing.T) {
+	privateKey := "-----BEGIN " + "OPENSSH PRIVATE KEY-----\n"
+	img := newTestImage(t,
+		map[string]string{"root/.ssh/id_ed25519": privateKey, "etc/hostname": "diana"},
+		map[string]string{"root/.ssh/.wh.id_ed25519": ""},
+		map[string]string{"app/key.pem": privateKey},
+	)
+
+	findings, err := Scan(context.Background(), img, defaultRules(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %v", findings)
+	}
+
+	expected := []Finding{
+		{Rule: "private-key", Layer: 0, Path: "/root/.ssh/id_ed25519", Deleted: true},
+		{Rule: "private-key", Layer: 2, Path: "/app/key.pem"},
+	}
+	for i, f := range findings {
+		e := expected[i]
+		if f.Rule != e.Rule || f.Layer != e.Layer || f.Path != e.Path || f.Deleted != e.Deleted {
+			t.Errorf("expected %+v, got %+v", e, f)
+		}
+		if f.LayerDigest != img.manifest.Layers[e.Layer].Digest {
+			t.Errorf("expected the digest of layer %d, got %s", e.Layer, f.LayerDigest)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package source
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/cedrickring/diana/pkg/archive"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+type archiveImage struct {
+	ref     Reference
+	archive *archive.Archive
+	image   *archive.Image
+	tmpFile string
+}
+
+func openDockerArchive(ref Reference) (Image, error) {
+	path, tag := splitPathReference(ref.Name)
+	return newArchiveImage(ref, path, tag)
+}
+
+func newArchiveImage(ref Reference, path, tag string) (*archiveImage, error) {
+	a, err := archive.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening docker archive %s", path)
+	}
+
+	img, err := a.Image(tag)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	return &archiveImage{
+		ref:     ref,
+		archive: a,
+		image:   img,
+	}, nil
+}
+
+func (a *archiveImage) Reference() Reference {
+	return a.ref
+}
+
+func (a *archiveImage) Manifest() *registry.Manifest {
+	return a.image.Manifest()
+}
+
+func (a *archiveImage) Config(context.Context) ([]byte, error) {
+	return a.image.RawConfig(), nil
+}
+
+func (a *archiveImage) Layer(_ context.Context, layer registry.Layer) (io.ReadCloser, error) {
+	return a.image.Layer(layer.Digest)
+}
+
+func (a *archiveImage) Close() error {
+	err := a.archive.Close()
+	if a.tmpFile != "" {
+		os.Remove(a.tmpFile)
+	}
+	return err
+}
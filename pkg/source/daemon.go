@@ -0,0 +1,62 @@
+package source
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/daemon"
+	"github.com/pkg/errors"
+)
+
+// openDaemon exports the image from the local Docker or Podman daemon into a temporary file.
+// The export holds all layers and the manifest comes last, so the whole image is written to disk
+// even if a single file is needed.
+func openDaemon(ctx context.Context, ref Reference, _ Options) (Image, error) {
+	socket, err := daemon.DetectSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	image, tag := daemonImage(ref.Name)
+	tarball, err := daemon.NewClient(socket).SaveImage(ctx, image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "exporting image %s", image)
+	}
+	defer tarball.Close()
+
+	f, err := ioutil.TempFile("", "diana-image-*.tar")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating temporary file")
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, tarball); err != nil {
+		os.Remove(f.Name())
+		return nil, errors.Wrapf(err, "exporting image %s", image)
+	}
+
+	img, err := newArchiveImage(ref, f.Name(), tag)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	img.tmpFile = f.Name()
+
+	return img, nil
+}
+
+// daemonImage returns the image to export and the tag to pick from the export. Exports of a
+// repository hold all its tags, so references without tag are exported as latest like the docker
+// CLI does. Images referenced by digest or ID aren't tagged in the export, which only holds them.
+func daemonImage(reference string) (image, tag string) {
+	if strings.Contains(reference, "@") || strings.HasPrefix(reference, "sha256:") {
+		return reference, ""
+	}
+	if !strings.Contains(reference[strings.LastIndex(reference, "/")+1:], ":") {
+		reference += ":latest"
+	}
+	return reference, reference
+}
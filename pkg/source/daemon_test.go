@@ -0,0 +1,166 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cedrickring/diana/pkg/registry"
+)
+
+// testArchiveImage is an image of a docker-archive with a single layer holding the files
+type testArchiveImage struct {
+	tags  []string
+	files map[string]string
+}
+
+func tarFiles(files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for path, content := range files {
+		tw.WriteHeader(&tar.Header{Name: path, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// writeDockerArchive writes the images like docker save and returns the digests of their configs
+func writeDockerArchive(t *testing.T, w io.Writer, images ...testArchiveImage) []string {
+	tw := tar.NewWriter(w)
+	add := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+	}
+
+	type manifestEntry struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	var manifest []manifestEntry
+	var configs []string
+	for _, img := range images {
+		layer := tarFiles(img.files)
+		layerName := registry.Digest(layer)[len("sha256:"):] + "/layer.tar"
+		add(layerName, layer)
+
+		platform := registry.DefaultPlatform()
+		config, _ := json.Marshal(map[string]interface{}{
+			"os":           platform.OS,
+			"architecture": platform.Architecture,
+			"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{registry.Digest(layer)}},
+		})
+		configName := registry.Digest(config)[len("sha256:"):] + ".json"
+		add(configName, config)
+
+		manifest = append(manifest, manifestEntry{Config: configName, RepoTags: img.tags, Layers: []string{layerName}})
+		configs = append(configs, registry.Digest(config))
+	}
+
+	b, _ := json.Marshal(manifest)
+	add("manifest.json", b)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return configs
+}
+
+// newTestDaemon serves the images on a unix socket like the docker daemon, the images are exported
+// regardless of the requested reference. It returns the references requested so far.
+func newTestDaemon(t *testing.T, images ...testArchiveImage) func() []string {
+	var buf bytes.Buffer
+	writeDockerArchive(t, &buf, images...)
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_HOST", "unix://"+socket)
+
+	var mu sync.Mutex
+	var requested []string
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		w.Write(buf.Bytes())
+	})}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+func TestOpenDaemon(t *testing.T) {
+	//an export of a repository holds all its tags
+	images := []testArchiveImage{
+		{tags: []string{"alpine:3.18"}, files: map[string]string{"etc/alpine-release": "3.18.0"}},
+		{tags: []string{"alpine:latest"}, files: map[string]string{"etc/alpine-release": "3.19.0"}},
+	}
+	var buf bytes.Buffer
+	configs := writeDockerArchive(t, &buf, images...)
+
+	tests := []struct {
+		reference string
+		path      string
+		config    string
+	}{
+		{reference: "alpine", path: "/images/alpine:latest/get", config: configs[1]},
+		{reference: "alpine:3.18", path: "/images/alpine:3.18/get", config: configs[0]},
+		{reference: "docker.io/library/alpine", path: "/images/docker.io/library/alpine:latest/get", config: configs[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reference, func(t *testing.T) {
+			requested := newTestDaemon(t, images...)
+
+			img, err := Open(context.Background(), TransportDaemon+":"+tt.reference, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer img.Close()
+
+			if r := requested(); len(r) != 1 || r[0] != tt.path {
+				t.Errorf("expected %s to be requested, got %v", tt.path, r)
+			}
+			if digest := img.Manifest().Config.Digest; digest != tt.config {
+				t.Errorf("expected the image with config %s, got %s", tt.config, digest)
+			}
+		})
+	}
+}
+
+func TestDaemonImage(t *testing.T) {
+	digest := "sha256:bc74621e18df78a5ce7d6b433d66cdecfa792a532d13b25697dcf2ce7154410c"
+
+	tests := []struct {
+		reference string
+		image     string
+		tag       string
+	}{
+		{reference: "alpine", image: "alpine:latest", tag: "alpine:latest"},
+		{reference: "alpine:3.18", image: "alpine:3.18", tag: "alpine:3.18"},
+		{reference: "localhost:5000/app", image: "localhost:5000/app:latest", tag: "localhost:5000/app:latest"},
+		{reference: "alpine@" + digest, image: "alpine@" + digest},
+		{reference: digest, image: digest},
+	}
+	for _, tt := range tests {
+		if image, tag := daemonImage(tt.reference); image != tt.image || tag != tt.tag {
+			t.Errorf("%s: expected %s and tag %q, got %s and %q", tt.reference, tt.image, tt.tag, image, tag)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package source
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/cedrickring/diana/pkg/containerd"
+	"github.com/cedrickring/diana/pkg/oci"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// blobImage is an image read from a local blob store (OCI layout or containerd)
+type blobImage struct {
+	ref    Reference
+	image  *oci.Image
+	tmpDir string
+}
+
+func openOCILayout(ref Reference, opts Options) (Image, error) {
+	dir, name := splitPathReference(ref.Name)
+	return newOCILayoutImage(ref, dir, name, opts)
+}
+
+func newOCILayoutImage(ref Reference, dir, name string, opts Options) (*blobImage, error) {
+	layout, err := oci.OpenLayout(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening OCI layout %s", dir)
+	}
+
+	img, err := layout.Image(name, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	return &blobImage{
+		ref:   ref,
+		image: img,
+	}, nil
+}
+
+// openOCIArchive unpacks the tarball of an OCI layout into a temporary directory
+func openOCIArchive(ref Reference, opts Options) (Image, error) {
+	path, name := splitPathReference(ref.Name)
+
+	tmp, err := ioutil.TempDir("", "diana-oci-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating temporary directory")
+	}
+
+	if err := unpackLayout(path, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return nil, errors.Wrapf(err, "unpacking OCI archive %s", path)
+	}
+
+	img, err := newOCILayoutImage(ref, tmp, name, opts)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	img.tmpDir = tmp
+
+	return img, nil
+}
+
+func unpackLayout(file, dst string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		//cleaning the rooted name makes sure nothing is written outside of dst
+		target := filepath.Join(dst, filepath.FromSlash(path.Clean("/"+header.Name)))
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func openContainerd(ref Reference, opts Options) (Image, error) {
+	root := opts.ContainerdRoot
+	if root == "" {
+		root = containerd.DefaultRoot
+	}
+
+	img, err := containerd.NewStore(root, opts.ContainerdNamespace).Image(ref.Name, opts.Platform)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading image %s from containerd", ref.Name)
+	}
+
+	return &blobImage{
+		ref:   ref,
+		image: img,
+	}, nil
+}
+
+func (b *blobImage) Reference() Reference {
+	return b.ref
+}
+
+func (b *blobImage) Manifest() *registry.Manifest {
+	return b.image.Manifest()
+}
+
+func (b *blobImage) Config(context.Context) ([]byte, error) {
+	return b.image.RawConfig(), nil
+}
+
+func (b *blobImage) Layer(_ context.Context, layer registry.Layer) (io.ReadCloser, error) {
+	return b.image.Layer(layer.Digest)
+}
+
+func (b *blobImage) Close() error {
+	if b.tmpDir != "" {
+		return os.RemoveAll(b.tmpDir)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package source
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type registryImage struct {
+	ref      Reference
+	imageRef name.Reference
+	client   registry.Client
+	manifest *registry.Manifest
+	useCache bool
+
+	mu     sync.Mutex
+	tmp    string
+	spools map[string]*spool
+}
+
+// spool is a layer downloaded to a temporary file, or the cache if it's used
+type spool struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+func openRegistry(ctx context.Context, ref Reference, opts Options) (Image, error) {
+	imageRef, err := name.ParseReference(ref.Name, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidReference, "%s: %v", ref.Name, err)
+	}
+
+	for _, mirror := range opts.Mirrors[imageRef.Context().RegistryStr()] {
+		mirrorRef, err := name.ParseReference(mirrorReference(imageRef, mirror), name.WeakValidation)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidReference, "mirror %s: %v", mirror, err)
+		}
+
+		img, err := openRegistryImage(ctx, ref, mirrorRef, opts)
+		if err == nil {
+			logrus.Debugf("Using mirror %s for %s", mirror, ref.Name)
+			return img, nil
+		}
+		logrus.WithError(err).Warnf("Failed to use mirror %s, trying the next one", mirror)
+	}
+
+	return openRegistryImage(ctx, ref, imageRef, opts)
+}
+
+// mirrorReference returns the reference of the image in the repository of the mirror, which
+// may contain a path prefix
+func mirrorReference(ref name.Reference, mirror string) string {
+	separator := ":"
+	if strings.HasPrefix(ref.Identifier(), "sha256:") {
+		separator = "@"
+	}
+	return strings.TrimSuffix(mirror, "/") + "/" + ref.Context().RepositoryStr() + separator + ref.Identifier()
+}
+
+func openRegistryImage(ctx context.Context, ref Reference, imageRef name.Reference, opts Options) (Image, error) {
+	repo := imageRef.Context()
+
+	var username, password string
+	if opts.Credentials != nil && !strings.HasPrefix(repo.RepositoryStr(), "library") {
+		var err error
+		username, password, err = opts.Credentials(repo.RegistryStr())
+		if err != nil {
+			return nil, errors.Wrap(err, "finding registry credentials")
+		}
+	}
+
+	var client registry.Client
+	if repo.RegistryStr() == name.DefaultRegistry { //Docker Hub
+		client = registry.NewDockerHubRegistryClient(username, password, opts.RegistryTransport)
+	} else {
+		client = registry.NewV2RegistryClient(username, password, opts.RegistryTransport)
+	}
+	//cached layers aren't downloaded, so they don't report progress
+	client = registry.WithProgress(client, opts.Progress)
+	if opts.Cache != nil {
+		client = opts.Cache.Client(client)
+	}
+
+	manifest, err := registry.ResolveManifest(ctx, client, imageRef, opts.Platform)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting manifest for image %s", imageRef)
+	}
+
+	return &registryImage{
+		ref:      ref,
+		imageRef: imageRef,
+		client:   client,
+		manifest: manifest,
+		useCache: opts.Cache != nil,
+		spools:   map[string]*spool{},
+	}, nil
+}
+
+func (r *registryImage) Reference() Reference {
+	return r.ref
+}
+
+func (r *registryImage) Manifest() *registry.Manifest {
+	return r.manifest
+}
+
+func (r *registryImage) Config(ctx context.Context) ([]byte, error) {
+	if r.manifest.Config.Digest == "" {
+		return nil, ErrNoConfig
+	}
+
+	blob, err := r.client.GetBlob(ctx, r.imageRef, r.manifest.Config.Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting image config")
+	}
+	defer blob.Close()
+
+	return ioutil.ReadAll(blob)
+}
+
+// Layer downloads the layer once, later calls read it from the cache or a temporary file
+func (r *registryImage) Layer(ctx context.Context, layer registry.Layer) (io.ReadCloser, error) {
+	if r.useCache {
+		//wait for a running prefetch, the layer is streamed into the cache otherwise
+		r.mu.Lock()
+		s, ok := r.spools[layer.Digest]
+		r.mu.Unlock()
+		if ok {
+			if err := s.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return r.client.GetLayer(ctx, r.imageRef, layer)
+	}
+
+	path, err := r.fetch(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Prefetch downloads the layer to a temporary file or the cache
+func (r *registryImage) Prefetch(ctx context.Context, layer registry.Layer) error {
+	_, err := r.fetch(ctx, layer)
+	return err
+}
+
+// fetch downloads the layer unless it's already downloaded or downloading, failed downloads
+// are retried by the next call
+func (r *registryImage) fetch(ctx context.Context, layer registry.Layer) (string, error) {
+	r.mu.Lock()
+	s, ok := r.spools[layer.Digest]
+	if !ok {
+		s = &spool{done: make(chan struct{})}
+		r.spools[layer.Digest] = s
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		s.path, s.err = r.spoolLayer(ctx, layer)
+		close(s.done)
+		if s.err != nil {
+			r.mu.Lock()
+			delete(r.spools, layer.Digest)
+			r.mu.Unlock()
+		}
+	}
+
+	if err := s.wait(ctx); err != nil {
+		return "", err
+	}
+	return s.path, nil
+}
+
+func (s *spool) wait(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// spoolLayer downloads the layer into a temporary file, with the cache the layer is only
+// read through it
+func (r *registryImage) spoolLayer(ctx context.Context, layer registry.Layer) (string, error) {
+	if r.useCache {
+		return "", r.download(ctx, layer, ioutil.Discard)
+	}
+
+	r.mu.Lock()
+	if r.tmp == "" {
+		tmp, err := ioutil.TempDir("", "diana")
+		if err != nil {
+			r.mu.Unlock()
+			return "", errors.Wrap(err, "creating temporary directory")
+		}
+		r.tmp = tmp
+	}
+	r.mu.Unlock()
+
+	f, err := ioutil.TempFile(r.tmp, "layer-*")
+	if err != nil {
+		return "", errors.Wrap(err, "creating temporary file")
+	}
+	defer f.Close()
+
+	if err := r.download(ctx, layer, f); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func (r *registryImage) download(ctx context.Context, layer registry.Layer, out io.Writer) error {
+	blob, err := r.client.GetLayer(ctx, r.imageRef, layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if _, err := io.Copy(out, blob); err != nil {
+		return errors.Wrapf(err, "pulling layer %s", layer.Digest)
+	}
+	return nil
+}
+
+func (r *registryImage) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tmp == "" {
+		return nil
+	}
+	return os.RemoveAll(r.tmp)
+}
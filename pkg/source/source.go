@@ -0,0 +1,154 @@
+package source
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cedrickring/diana/pkg/cache"
+	"github.com/cedrickring/diana/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+const (
+	TransportDocker        = "docker"
+	TransportDaemon        = "docker-daemon"
+	TransportDockerArchive = "docker-archive"
+	TransportOCI           = "oci"
+	TransportOCIArchive    = "oci-archive"
+	TransportContainerd    = "containerd"
+)
+
+var transports = []string{
+	TransportDocker,
+	TransportDaemon,
+	TransportDockerArchive,
+	TransportOCI,
+	TransportOCIArchive,
+	TransportContainerd,
+}
+
+// ErrNoConfig is returned by Image.Config for images without a config blob (schema1)
+var ErrNoConfig = errors.New("image has no config")
+
+// ErrInvalidReference is the cause of errors about malformed image references
+var ErrInvalidReference = errors.New("invalid image reference")
+
+// Image provides the manifest, config and layers of an image regardless of where it's stored
+type Image interface {
+	// Reference is the transport prefixed reference the image was opened with
+	Reference() Reference
+	Manifest() *registry.Manifest
+	// Config returns the raw image config
+	Config(ctx context.Context) ([]byte, error)
+	// Layer opens the (possibly compressed) layer blob. Layers may be opened multiple times.
+	// The content is verified at EOF, so it has to be read to EOF before it's trusted.
+	Layer(ctx context.Context, layer registry.Layer) (io.ReadCloser, error)
+	// Close releases all resources like temporary files
+	Close() error
+}
+
+type Options struct {
+	Platform registry.Platform
+	// DefaultTransport is used for references without transport prefix, defaults to docker
+	DefaultTransport string
+
+	// RegistryTransport is used for all requests to registries, defaults to http.DefaultTransport
+	RegistryTransport http.RoundTripper
+	// Credentials returns the credentials for a registry, anonymous access is used if nil
+	Credentials func(registry string) (username, password string, err error)
+	// Cache keeps layers pulled from registries across runs if not nil
+	Cache *cache.Cache
+	// Progress is notified about layers downloaded from registries if not nil
+	Progress registry.Progress
+	// Mirrors are tried in order before the registry itself, keyed by registry host
+	Mirrors map[string][]string
+
+	ContainerdRoot      string
+	ContainerdNamespace string
+}
+
+// Prefetcher is implemented by images which can download layers ahead of time, so opening
+// them later doesn't have to wait for the download
+type Prefetcher interface {
+	Prefetch(ctx context.Context, layer registry.Layer) error
+}
+
+// Reference is an image reference with a transport, e.g. docker-archive:image.tar:app:latest
+type Reference struct {
+	Transport string
+	Name      string
+}
+
+func (r Reference) String() string {
+	if r.Transport == TransportDocker {
+		return r.Name
+	}
+	return r.Transport + ":" + r.Name
+}
+
+// ParseReference splits the transport prefix off the reference. Both transport:name and
+// transport://name are accepted, references without prefix use the default transport.
+func ParseReference(s string, defaultTransport string) (Reference, error) {
+	for _, t := range transports {
+		if strings.HasPrefix(s, t+":") {
+			name := strings.TrimPrefix(strings.TrimPrefix(s, t+":"), "//")
+			if name == "" {
+				return Reference{}, errors.Wrapf(ErrInvalidReference, "missing image name in %s", s)
+			}
+			return Reference{Transport: t, Name: name}, nil
+		}
+	}
+
+	if defaultTransport == "" {
+		defaultTransport = TransportDocker
+	}
+	if !isTransport(defaultTransport) {
+		return Reference{}, errors.Errorf("unknown transport %s", defaultTransport)
+	}
+	return Reference{Transport: defaultTransport, Name: s}, nil
+}
+
+func isTransport(t string) bool {
+	for _, transport := range transports {
+		if transport == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Open resolves the reference to an image, the caller has to close it
+func Open(ctx context.Context, reference string, opts Options) (Image, error) {
+	ref, err := ParseReference(reference, opts.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ref.Transport {
+	case TransportDocker:
+		return openRegistry(ctx, ref, opts)
+	case TransportDaemon:
+		return openDaemon(ctx, ref, opts)
+	case TransportDockerArchive:
+		return openDockerArchive(ref)
+	case TransportOCI:
+		return openOCILayout(ref, opts)
+	case TransportOCIArchive:
+		return openOCIArchive(ref, opts)
+	case TransportContainerd:
+		return openContainerd(ref, opts)
+	default:
+		return nil, errors.Errorf("unknown transport %s", ref.Transport)
+	}
+}
+
+// splitPathReference splits path[:reference], paths containing a colon aren't supported
+func splitPathReference(s string) (path, reference string) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
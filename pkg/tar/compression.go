@@ -0,0 +1,90 @@
+package tar
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+// CompressionFromMediaType determines the compression of a layer by its media type.
+// ok is false if the media type doesn't tell.
+func CompressionFromMediaType(mediaType string) (compression Compression, ok bool) {
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip"), strings.HasSuffix(mediaType, ".tar.gzip"):
+		return Gzip, true
+	case strings.HasSuffix(mediaType, "+zstd"):
+		return Zstd, true
+	case strings.HasSuffix(mediaType, ".tar"):
+		return Uncompressed, true
+	default:
+		return Uncompressed, false
+	}
+}
+
+// Decompress returns a reader for the uncompressed tar stream of a layer. The compression is
+// chosen from the media type and falls back to sniffing the magic bytes if it's unknown.
+func Decompress(r io.Reader, mediaType string) (io.ReadCloser, error) {
+	compression, ok := CompressionFromMediaType(mediaType)
+	if !ok {
+		br := bufio.NewReader(r)
+		compression = sniffCompression(br)
+		r = br
+	}
+
+	switch compression {
+	case Gzip:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating gzip reader")
+		}
+		return gzr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating zstd reader")
+		}
+		return zstdReadCloser{zr}, nil
+	default:
+		return ioutil.NopCloser(r), nil
+	}
+}
+
+func sniffCompression(br *bufio.Reader) Compression {
+	header, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	default:
+		return Uncompressed
+	}
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,117 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func testLayer(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func compress(t *testing.T, layer []byte, compression Compression) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Zstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	default:
+		return layer
+	}
+	w.Write(layer)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressionFromMediaType(t *testing.T) {
+	tests := []struct {
+		mediaType   string
+		compression Compression
+		ok          bool
+	}{
+		{mediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", compression: Gzip, ok: true},
+		{mediaType: "application/vnd.oci.image.layer.v1.tar+gzip", compression: Gzip, ok: true},
+		{mediaType: "application/vnd.oci.image.layer.v1.tar+zstd", compression: Zstd, ok: true},
+		{mediaType: "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd", compression: Zstd, ok: true},
+		{mediaType: "application/vnd.oci.image.layer.v1.tar", compression: Uncompressed, ok: true},
+		{mediaType: "", compression: Uncompressed},
+		{mediaType: "application/octet-stream", compression: Uncompressed},
+	}
+	for _, tt := range tests {
+		if compression, ok := CompressionFromMediaType(tt.mediaType); compression != tt.compression || ok != tt.ok {
+			t.Errorf("%q: expected %d, %v, got %d, %v", tt.mediaType, tt.compression, tt.ok, compression, ok)
+		}
+	}
+}
+
+func TestForEachDecompressesLayers(t *testing.T) {
+	layer := testLayer(t, map[string]string{"etc/hostname": "diana"})
+
+	tests := []struct {
+		name        string
+		compression Compression
+		mediaType   string
+	}{
+		{name: "gzip", compression: Gzip, mediaType: "application/vnd.oci.image.layer.v1.tar+gzip"},
+		{name: "zstd", compression: Zstd, mediaType: "application/vnd.oci.image.layer.v1.tar+zstd"},
+		{name: "uncompressed", compression: Uncompressed, mediaType: "application/vnd.oci.image.layer.v1.tar"},
+		//layers of docker-archives and unknown media types are sniffed
+		{name: "sniffed gzip", compression: Gzip},
+		{name: "sniffed zstd", compression: Zstd},
+		{name: "sniffed uncompressed", compression: Uncompressed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := bytes.NewReader(compress(t, layer, tt.compression))
+
+			files := map[string]string{}
+			err := ForEach(in, tt.mediaType, func(header *tar.Header, content io.Reader) error {
+				b, err := ioutil.ReadAll(content)
+				files[header.Name] = string(b)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files["etc/hostname"] != "diana" {
+				t.Errorf("expected etc/hostname, got %v", files)
+			}
+			if in.Len() != 0 {
+				t.Errorf("expected the layer to be consumed, %d bytes left", in.Len())
+			}
+		})
+	}
+}
+
+func TestDecompressRejectsMismatchingMediaType(t *testing.T) {
+	layer := compress(t, testLayer(t, map[string]string{"etc/hostname": "diana"}), Zstd)
+
+	if _, err := Decompress(bytes.NewReader(layer), "application/vnd.oci.image.layer.v1.tar+gzip"); err == nil {
+		t.Error("expected a zstd layer not to be read as gzip")
+	}
+}
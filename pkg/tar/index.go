@@ -0,0 +1,356 @@
+package tar
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutMeta   = whiteoutPrefix + whiteoutPrefix
+	whiteoutOpaque = whiteoutMeta + ".opq"
+
+	maxSymlinks = 255
+)
+
+// Entry is a file of the merged filesystem of all layers
+type Entry struct {
+	// Path is the absolute, cleaned path of the file
+	Path   string
+	Header *tar.Header
+	// Layer is the index of the layer which added the file
+	Layer int
+
+	// ContentLayer and ContentPath locate the content of regular files and hardlinks,
+	// ContentLayer is -1 if there's no content
+	ContentLayer int
+	ContentPath  string
+}
+
+// IsDir reports whether the entry is a directory
+func (e *Entry) IsDir() bool {
+	return e.Header.Typeflag == tar.TypeDir
+}
+
+// IsSymlink reports whether the entry is a symbolic link
+func (e *Entry) IsSymlink() bool {
+	return e.Header.Typeflag == tar.TypeSymlink
+}
+
+// HasContent reports whether the entry is a regular file or a hardlink to one
+func (e *Entry) HasContent() bool {
+	return e.ContentLayer >= 0
+}
+
+// Version is a change of a path by a layer
+type Version struct {
+	Layer int
+	// Entry is the entry added by the layer, or the entry removed by it if Deleted is set
+	Entry   *Entry
+	Deleted bool
+}
+
+// Index is the merged view of the file headers of all applied layers, honoring whiteouts
+type Index struct {
+	entries map[string]*Entry
+	//history contains every change of a path, including removed ones
+	history map[string][]Version
+}
+
+func NewIndex() *Index {
+	return &Index{
+		history: map[string][]Version{},
+		entries: map[string]*Entry{
+			"/": {
+				Path:         "/",
+				Header:       &tar.Header{Name: "/", Typeflag: tar.TypeDir, Mode: 0755},
+				ContentLayer: -1,
+			},
+		},
+	}
+}
+
+// CleanPath returns the absolute, cleaned form of a path in a layer
+func CleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// ForEach calls fn for every file of the (compressed) layer. The layer is consumed up to
+// EOF, so wrapping readers are able to verify the content.
+func ForEach(in io.Reader, mediaType string, fn func(header *tar.Header, content io.Reader) error) error {
+	r, err := Decompress(in, mediaType)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "reading layer")
+		}
+
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(ioutil.Discard, in)
+	return err
+}
+
+// Apply adds the files of the layer on top of the index
+func (i *Index) Apply(layer int, in io.Reader, mediaType string) error {
+	return ForEach(in, mediaType, func(header *tar.Header, _ io.Reader) error {
+		i.Add(layer, header)
+		return nil
+	})
+}
+
+// Add applies a single file header of the layer, layers have to be added in order
+func (i *Index) Add(layer int, header *tar.Header) {
+	p := CleanPath(header.Name)
+	dir, base := path.Split(p)
+
+	switch {
+	case base == whiteoutOpaque:
+		i.removeChildren(path.Clean(dir), layer)
+		return
+	case strings.HasPrefix(base, whiteoutMeta):
+		//other aufs metadata
+		return
+	case strings.HasPrefix(base, whiteoutPrefix):
+		i.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), layer)
+		return
+	}
+
+	if existing, ok := i.entries[p]; ok && existing.IsDir() && header.Typeflag != tar.TypeDir {
+		i.removeChildrenUpTo(p, layer)
+	}
+
+	h := *header
+	h.Name = p
+	entry := &Entry{
+		Path:         p,
+		Header:       &h,
+		Layer:        layer,
+		ContentLayer: -1,
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		entry.ContentLayer = layer
+		entry.ContentPath = p
+	case tar.TypeLink:
+		//hardlinks keep the content of the target even if it's replaced later on
+		if target, ok := i.entries[CleanPath(header.Linkname)]; ok && target.HasContent() {
+			entry.ContentLayer = target.ContentLayer
+			entry.ContentPath = target.ContentPath
+			h.Size = target.Header.Size
+		}
+	}
+
+	i.addParents(p, layer)
+	i.entries[p] = entry
+	i.history[p] = append(i.history[p], Version{Layer: layer, Entry: entry})
+}
+
+// addParents creates directories missing because their layer hasn't been applied
+func (i *Index) addParents(p string, layer int) {
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		if _, ok := i.entries[dir]; ok {
+			return
+		}
+		i.entries[dir] = &Entry{
+			Path:         dir,
+			Header:       &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755},
+			Layer:        layer,
+			ContentLayer: -1,
+		}
+	}
+}
+
+// remove deletes the path and all of its children added by layers below
+func (i *Index) remove(p string, layer int) {
+	if e, ok := i.entries[p]; ok && e.Layer < layer {
+		i.delete(e, layer)
+	}
+	i.removeChildren(p, layer)
+}
+
+func (i *Index) removeChildren(dir string, layer int) {
+	i.removeChildrenBelow(dir, layer, layer)
+}
+
+// removeChildrenUpTo also removes the children added by the layer itself
+func (i *Index) removeChildrenUpTo(dir string, layer int) {
+	i.removeChildrenBelow(dir, layer+1, layer)
+}
+
+func (i *Index) removeChildrenBelow(dir string, below int, layer int) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for p, e := range i.entries {
+		if strings.HasPrefix(p, prefix) && e.Layer < below {
+			i.delete(e, layer)
+		}
+	}
+}
+
+func (i *Index) delete(e *Entry, layer int) {
+	delete(i.entries, e.Path)
+	i.history[e.Path] = append(i.history[e.Path], Version{Layer: layer, Entry: e, Deleted: true})
+}
+
+// Lookup returns the entry without following symlinks
+func (i *Index) Lookup(p string) (*Entry, bool) {
+	e, ok := i.entries[CleanPath(p)]
+	return e, ok
+}
+
+// Resolve returns the entry of the path, following symlinks in all path components
+// without ever leaving the filesystem root
+func (i *Index) Resolve(p string) (*Entry, error) {
+	e, _, err := i.ResolveLinks(p)
+	return e, err
+}
+
+// ResolveLinks is like Resolve, but also returns the symlinks which were followed in order
+func (i *Index) ResolveLinks(p string) (*Entry, []*Entry, error) {
+	return ResolveFunc(p, func(p string) (*Entry, error) {
+		return i.entries[p], nil
+	})
+}
+
+// ResolveFunc resolves the path like Index.ResolveLinks, looking up entries with lookup. It
+// returns nil if the path doesn't exist.
+func ResolveFunc(p string, lookup func(p string) (*Entry, error)) (*Entry, []*Entry, error) {
+	var links []*Entry
+	resolved, err := resolve(CleanPath(p), 0, &links, lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e, err := lookup(resolved)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e == nil {
+		return nil, nil, errors.Wrap(os.ErrNotExist, p)
+	}
+	return e, links, nil
+}
+
+func resolve(p string, hops int, links *[]*Entry, lookup func(p string) (*Entry, error)) (string, error) {
+	current := "/"
+	components := strings.Split(strings.TrimPrefix(p, "/"), "/")
+
+	for n, component := range components {
+		if component == "" {
+			continue
+		}
+		next := path.Join(current, component)
+
+		e, err := lookup(next)
+		if err != nil {
+			return "", err
+		}
+		if e == nil {
+			//the remaining path doesn't exist, return it unresolved
+			return path.Join(append([]string{next}, components[n+1:]...)...), nil
+		}
+
+		if e.IsSymlink() {
+			if hops >= maxSymlinks {
+				return "", errors.Errorf("too many levels of symbolic links in %s", p)
+			}
+			*links = append(*links, e)
+
+			target := e.Header.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(current, target)
+			}
+
+			resolved, err := resolve(CleanPath(target), hops+1, links, lookup)
+			if err != nil {
+				return "", err
+			}
+			hops++
+			next = resolved
+		}
+
+		current = next
+	}
+
+	return current, nil
+}
+
+// Entries returns all entries sorted by path
+func (i *Index) Entries() []*Entry {
+	entries := make([]*Entry, 0, len(i.entries))
+	for _, e := range i.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Path < entries[b].Path
+	})
+	return entries
+}
+
+// Versions returns all changes of the path in the order of the layers, symlinks aren't followed
+func (i *Index) Versions(p string) []Version {
+	return i.history[CleanPath(p)]
+}
+
+// Occurrences returns how often the layer adds the path, the index holds the last occurrence
+func (i *Index) Occurrences(p string, layer int) int {
+	n := 0
+	for _, v := range i.history[CleanPath(p)] {
+		if v.Layer == layer && !v.Deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// Deleted returns the last entry of every path which was removed by a layer and not added
+// again, sorted by path
+func (i *Index) Deleted() []*Entry {
+	var deleted []*Entry
+	for p, versions := range i.history {
+		if _, ok := i.entries[p]; ok {
+			continue
+		}
+		//the last version is the deletion of the last entry
+		deleted = append(deleted, versions[len(versions)-1].Entry)
+	}
+	sort.Slice(deleted, func(a, b int) bool {
+		return deleted[a].Path < deleted[b].Path
+	})
+	return deleted
+}
+
+// Children returns the direct children of the directory sorted by name
+func (i *Index) Children(dir string) []*Entry {
+	dir = CleanPath(dir)
+
+	var children []*Entry
+	for p, e := range i.entries {
+		if p != "/" && path.Dir(p) == dir {
+			children = append(children, e)
+		}
+	}
+	sort.Slice(children, func(a, b int) bool {
+		return children[a].Path < children[b].Path
+	})
+	return children
+}
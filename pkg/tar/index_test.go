@@ -0,0 +1,244 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// testIndex applies the layers, names ending in / are directories and all others regular files
+func testIndex(layers ...[]string) *Index {
+	index := NewIndex()
+	for layer, names := range layers {
+		for _, name := range names {
+			h := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+			if strings.HasSuffix(name, "/") {
+				h.Typeflag = tar.TypeDir
+				h.Mode = 0755
+			}
+			index.Add(layer, h)
+		}
+	}
+	return index
+}
+
+func paths(entries []*Entry) []string {
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestIndexWhiteouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		layers   [][]string
+		expected []string
+		deleted  []string
+	}{
+		{
+			name: "file",
+			layers: [][]string{
+				{"etc/", "etc/hostname", "etc/hosts"},
+				{"etc/.wh.hostname"},
+			},
+			expected: []string{"/", "/etc", "/etc/hosts"},
+			deleted:  []string{"/etc/hostname"},
+		},
+		{
+			name: "directory with children",
+			layers: [][]string{
+				{"var/", "var/cache/", "var/cache/apk/", "var/cache/apk/index", "var/log"},
+				{"var/.wh.cache"},
+			},
+			expected: []string{"/", "/var", "/var/log"},
+			deleted:  []string{"/var/cache", "/var/cache/apk", "/var/cache/apk/index"},
+		},
+		{
+			name: "re-added in a later layer",
+			layers: [][]string{
+				{"etc/", "etc/hostname"},
+				{"etc/.wh.hostname"},
+				{"etc/hostname"},
+			},
+			expected: []string{"/", "/etc", "/etc/hostname"},
+		},
+		{
+			//whiteouts only hide files of lower layers, regardless of the order in the tarball
+			name: "same layer",
+			layers: [][]string{
+				{"etc/", "etc/hostname"},
+				{"etc/hostname", "etc/.wh.hostname"},
+			},
+			expected: []string{"/", "/etc", "/etc/hostname"},
+		},
+		{
+			name: "opaque directory",
+			layers: [][]string{
+				{"etc/", "etc/apk/", "etc/apk/repositories", "etc/apk/keys/", "etc/apk/keys/key.pub", "etc/hostname"},
+				{"etc/apk/", "etc/apk/world", "etc/apk/.wh..wh..opq"},
+			},
+			expected: []string{"/", "/etc", "/etc/apk", "/etc/apk/world", "/etc/hostname"},
+			deleted:  []string{"/etc/apk/keys", "/etc/apk/keys/key.pub", "/etc/apk/repositories"},
+		},
+		{
+			name: "other aufs metadata",
+			layers: [][]string{
+				{"etc/", "etc/hostname"},
+				{".wh..wh.plnk/", ".wh..wh.aufs"},
+			},
+			expected: []string{"/", "/etc", "/etc/hostname"},
+		},
+		{
+			name: "directory replaced by a file",
+			layers: [][]string{
+				{"lib/", "lib/libc.so", "lib/modules/"},
+				{"lib"},
+			},
+			expected: []string{"/", "/lib"},
+			deleted:  []string{"/lib/libc.so", "/lib/modules"},
+		},
+		{
+			name: "missing parents",
+			layers: [][]string{
+				{"usr/local/bin/app"},
+			},
+			expected: []string{"/", "/usr", "/usr/local", "/usr/local/bin", "/usr/local/bin/app"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := testIndex(tt.layers...)
+
+			if p := paths(index.Entries()); !reflect.DeepEqual(p, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, p)
+			}
+			if p := paths(index.Deleted()); !reflect.DeepEqual(p, tt.deleted) {
+				t.Errorf("expected %v to be deleted, got %v", tt.deleted, p)
+			}
+		})
+	}
+}
+
+func TestIndexVersions(t *testing.T) {
+	index := testIndex(
+		[]string{"etc/", "etc/hostname"},
+		[]string{"etc/.wh.hostname"},
+		[]string{"etc/hostname", "etc/hostname"},
+	)
+
+	var layers []int
+	var deleted []bool
+	for _, v := range index.Versions("etc/hostname") {
+		layers = append(layers, v.Layer)
+		deleted = append(deleted, v.Deleted)
+	}
+	if !reflect.DeepEqual(layers, []int{0, 1, 2, 2}) || !reflect.DeepEqual(deleted, []bool{false, true, false, false}) {
+		t.Errorf("unexpected versions: layers %v, deleted %v", layers, deleted)
+	}
+	if n := index.Occurrences("/etc/hostname", 2); n != 2 {
+		t.Errorf("expected 2 occurrences in layer 2, got %d", n)
+	}
+	if n := index.Occurrences("/etc/hostname", 1); n != 0 {
+		t.Errorf("expected no occurrences in layer 1, got %d", n)
+	}
+}
+
+func TestIndexHardlinks(t *testing.T) {
+	index := NewIndex()
+	index.Add(0, &tar.Header{Name: "bin/busybox", Typeflag: tar.TypeReg, Size: 7})
+	index.Add(0, &tar.Header{Name: "bin/sh", Typeflag: tar.TypeLink, Linkname: "bin/busybox"})
+	//replacing the target keeps the content of the link
+	index.Add(1, &tar.Header{Name: "bin/busybox", Typeflag: tar.TypeReg, Size: 3})
+
+	e, ok := index.Lookup("/bin/sh")
+	if !ok {
+		t.Fatal("expected /bin/sh to exist")
+	}
+	if e.ContentLayer != 0 || e.ContentPath != "/bin/busybox" || e.Header.Size != 7 {
+		t.Errorf("expected the content of the original busybox, got layer %d, %s, size %d", e.ContentLayer, e.ContentPath, e.Header.Size)
+	}
+}
+
+func TestIndexResolve(t *testing.T) {
+	index := NewIndex()
+	index.Add(0, &tar.Header{Name: "usr/lib/libc.so", Typeflag: tar.TypeReg})
+	index.Add(0, &tar.Header{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "usr/lib"})
+	index.Add(0, &tar.Header{Name: "usr/lib/escape", Typeflag: tar.TypeSymlink, Linkname: "../../../../usr/lib/libc.so"})
+	index.Add(0, &tar.Header{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "/loop"})
+
+	tests := []struct {
+		path     string
+		expected string
+		links    int
+	}{
+		{path: "/lib/libc.so", expected: "/usr/lib/libc.so", links: 1},
+		//symlinks never leave the root
+		{path: "/lib/escape", expected: "/usr/lib/libc.so", links: 2},
+		{path: "/usr/lib/libc.so", expected: "/usr/lib/libc.so"},
+	}
+	for _, tt := range tests {
+		e, links, err := index.ResolveLinks(tt.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+			continue
+		}
+		if e.Path != tt.expected || len(links) != tt.links {
+			t.Errorf("%s: expected %s via %d links, got %s via %d", tt.path, tt.expected, tt.links, e.Path, len(links))
+		}
+	}
+
+	if _, err := index.Resolve("/lib/missing"); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("expected a missing file to fail with not exist, got %v", err)
+	}
+	if _, err := index.Resolve("/loop"); err == nil {
+		t.Error("expected a symlink loop to fail")
+	}
+}
+
+func TestLayerIndexHides(t *testing.T) {
+	layer := testLayer(t, map[string]string{
+		"etc/.wh.hostname":     "",
+		"etc/apk/.wh..wh..opq": "",
+		"etc/apk/world":        "alpine-base",
+		"lib":                  "",
+	})
+	l, err := ReadLayer(bytes.NewReader(layer), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		hides bool
+	}{
+		{path: "/etc/hostname", hides: true},
+		{path: "/etc/hosts"},
+		{path: "/etc/apk/repositories", hides: true},
+		{path: "/etc/apk/keys/key.pub", hides: true},
+		//the layer replaces /lib by a file
+		{path: "/lib/libc.so", hides: true},
+		{path: "/usr/lib/libc.so"},
+	}
+	for _, tt := range tests {
+		if hides := l.Hides(tt.path); hides != tt.hides {
+			t.Errorf("%s: expected hides to be %v", tt.path, tt.hides)
+		}
+	}
+
+	if !l.Opaque("/etc/apk") || l.Opaque("/etc") {
+		t.Error("expected only /etc/apk to be opaque")
+	}
+	if _, ok := l.Header("/etc/.wh.hostname"); ok {
+		t.Error("expected whiteouts not to be added as files")
+	}
+	if !l.Contains("/etc") || l.Contains("/usr") {
+		t.Error("expected the layer to contain files below /etc only")
+	}
+}
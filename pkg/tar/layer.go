@@ -0,0 +1,128 @@
+package tar
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+)
+
+// LayerIndex holds the file headers of a single layer including its whiteouts, so lower layers
+// only have to be read if the layer doesn't determine a path on its own
+type LayerIndex struct {
+	headers map[string]*tar.Header
+	//removed are the paths deleted by whiteouts
+	removed map[string]bool
+	//opaque are the directories hiding the content of lower layers
+	opaque map[string]bool
+	//children are the direct children of every directory with files in the layer
+	children map[string]map[string]bool
+}
+
+// ReadLayer reads the headers of the (compressed) layer
+func ReadLayer(in io.Reader, mediaType string) (*LayerIndex, error) {
+	l := &LayerIndex{
+		headers:  map[string]*tar.Header{},
+		removed:  map[string]bool{},
+		opaque:   map[string]bool{},
+		children: map[string]map[string]bool{},
+	}
+
+	err := ForEach(in, mediaType, func(header *tar.Header, _ io.Reader) error {
+		l.add(header)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LayerIndex) add(header *tar.Header) {
+	p := CleanPath(header.Name)
+	dir, base := path.Split(p)
+	dir = path.Clean(dir)
+
+	switch {
+	case base == whiteoutOpaque:
+		l.opaque[dir] = true
+		l.addChild(dir)
+		return
+	case strings.HasPrefix(base, whiteoutMeta):
+		//other aufs metadata
+		return
+	case strings.HasPrefix(base, whiteoutPrefix):
+		removed := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+		l.removed[removed] = true
+		l.addChild(removed)
+		return
+	}
+
+	h := *header
+	h.Name = p
+	l.headers[p] = &h
+	delete(l.removed, p)
+	l.addChild(p)
+}
+
+// addChild registers the path and its parents as children of their directories
+func (l *LayerIndex) addChild(p string) {
+	for p != "/" {
+		dir := path.Dir(p)
+		if l.children[dir] == nil {
+			l.children[dir] = map[string]bool{}
+		}
+		l.children[dir][p] = true
+		p = dir
+	}
+}
+
+// Header returns the header the layer adds at the path
+func (l *LayerIndex) Header(p string) (*tar.Header, bool) {
+	h, ok := l.headers[p]
+	return h, ok
+}
+
+// Contains reports whether the layer adds files below the directory
+func (l *LayerIndex) Contains(dir string) bool {
+	for child := range l.children[dir] {
+		if !l.removed[child] {
+			return true
+		}
+	}
+	return false
+}
+
+// Children returns the paths of the direct children of the directory the layer adds or
+// removes, including directories which only exist implicitly
+func (l *LayerIndex) Children(dir string) []string {
+	var children []string
+	for child := range l.children[dir] {
+		children = append(children, child)
+	}
+	return children
+}
+
+// Opaque reports whether the layer hides the content of the directory in lower layers
+func (l *LayerIndex) Opaque(dir string) bool {
+	return l.opaque[dir]
+}
+
+// Hides reports whether the layer hides the path of lower layers. That's the case if the path
+// or one of its parents is removed, a parent is opaque or a parent isn't a directory anymore.
+func (l *LayerIndex) Hides(p string) bool {
+	if l.removed[p] {
+		return true
+	}
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if l.removed[dir] || l.opaque[dir] {
+			return true
+		}
+		if h, ok := l.headers[dir]; ok && h.Typeflag != tar.TypeDir {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
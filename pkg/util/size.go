@@ -0,0 +1,49 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses sizes like 512MB or 5GB (binary units), plain numbers are bytes
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// FormatSize formats bytes with the largest fitting binary unit
+func FormatSize(bytes int64) string {
+	for _, unit := range sizeUnits {
+		if bytes >= unit.factor && unit.factor > 1 {
+			return fmt.Sprintf("%.1f %s", float64(bytes)/float64(unit.factor), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}